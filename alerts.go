@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type SavingsAlert struct {
+	ClusterSavings float64           `json:"cluster_savings"`
+	Currency       string            `json:"currency"`
+	Pods           []PodSavingsAlert `json:"pods,omitempty"`
+}
+
+type PodSavingsAlert struct {
+	PodName   string  `json:"pod_name"`
+	Namespace string  `json:"namespace"`
+	Savings   float64 `json:"savings"`
+}
+
+// alertDeduplicator помнит, когда по ключу последний раз отправлялось уведомление
+type alertDeduplicator struct {
+	mu       sync.Mutex
+	interval time.Duration
+	sent     map[string]time.Time
+}
+
+func newAlertDeduplicator(interval time.Duration) *alertDeduplicator {
+	return &alertDeduplicator{
+		interval: interval,
+		sent:     make(map[string]time.Time),
+	}
+}
+
+// allow возвращает true, если по ключу не было уведомлений в течение interval. Отправка
+// не запоминается: ее отмечает record, когда уведомление действительно доставлено.
+func (d *alertDeduplicator) allow(key string, now time.Time) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	last, ok := d.sent[key]
+	return !ok || now.Sub(last) >= d.interval
+}
+
+// record запоминает отправку уведомления по ключам и забывает ключи старше interval,
+// чтобы ушедшие поды не копились в памяти
+func (d *alertDeduplicator) record(keys []string, now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for key, last := range d.sent {
+		if now.Sub(last) >= d.interval {
+			delete(d.sent, key)
+		}
+	}
+	for _, key := range keys {
+		d.sent[key] = now
+	}
+}
+
+// podSavings возвращает экономию в рублях от применения рекомендаций к поду
+func (ma *MetricsAnalyzer) podSavings(metrics PodMetrics) float64 {
+	cpuDelta := metrics.CurrentCPU - metrics.RecommendCPU
+	memDeltaMB := (metrics.CurrentMemory - metrics.RecommendMem) / (1024 * 1024)
+	return (cpuDelta * ma.config.CPUCostPerCore) + (memDeltaMB * ma.config.MemoryCostPerMB)
+}
+
+// notifySavings отправляет на AlertWebhookURL уведомление о подах и кластере, чья экономия превысила пороги.
+// Один и тот же под (и кластер целиком) не повторяется чаще, чем раз в AlertDedupInterval.
+func (ma *MetricsAnalyzer) notifySavings(stats ClusterStats) {
+	if ma.config.AlertWebhookURL == "" {
+		return
+	}
+
+	now := time.Now()
+	var alert SavingsAlert
+	var keys []string // Ключи дедупликации, отмечаются только после успешной отправки
+
+	if ma.config.AlertClusterSavingsThreshold > 0 && stats.PotentialSavings >= ma.config.AlertClusterSavingsThreshold {
+		if ma.alertDedup.allow("cluster", now) {
+			keys = append(keys, "cluster")
+		}
+	}
+	alert.ClusterSavings = stats.PotentialSavings
+	alert.Currency = stats.Currency
+
+	if ma.config.AlertPodSavingsThreshold > 0 {
+		for _, pod := range stats.Pods {
+			savings := ma.podSavings(pod)
+			if savings < ma.config.AlertPodSavingsThreshold {
+				continue
+			}
+			key := pod.Namespace + "/" + pod.PodName
+			if !ma.alertDedup.allow(key, now) {
+				continue
+			}
+			alert.Pods = append(alert.Pods, PodSavingsAlert{
+				PodName:   pod.PodName,
+				Namespace: pod.Namespace,
+				Savings:   savings,
+			})
+			keys = append(keys, key)
+		}
+	}
+
+	if len(keys) == 0 {
+		return
+	}
+	if err := ma.postWebhook(alert); err != nil {
+		log.Printf("Error sending savings alert: %v", err)
+		return
+	}
+	ma.alertDedup.record(keys, now)
+	log.Printf("Savings alert sent: cluster savings %.2f %s, %d pods", alert.ClusterSavings, alert.Currency, len(alert.Pods))
+}
+
+func (ma *MetricsAnalyzer) postWebhook(alert SavingsAlert) error {
+	payload, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(ma.config.AlertWebhookURL, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFailedAlertIsRetried(t *testing.T) {
+	var calls, failures atomic.Int32
+	failures.Store(1)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if failures.Add(-1) >= 0 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		}
+	}))
+	defer webhook.Close()
+
+	config := testConfig()
+	config.AlertWebhookURL = webhook.URL
+	config.AlertPodSavingsThreshold = 100
+	config.AlertDedupInterval = 24 * time.Hour
+	analyzer := &MetricsAnalyzer{config: config, alertDedup: newAlertDeduplicator(config.AlertDedupInterval)}
+	stats := ClusterStats{Pods: []PodMetrics{{PodName: "web", Namespace: "default", CurrentCPU: 2, RecommendCPU: 0.5}}}
+
+	// Первая отправка падает и не должна занять интервал дедупликации, вторая доходит,
+	// а третья подавляется как повтор
+	for i := 0; i < 3; i++ {
+		analyzer.notifySavings(stats)
+	}
+	if got := calls.Load(); got != 2 {
+		t.Errorf("webhook called %d times, want 2", got)
+	}
+}
+
+func TestAlertDeduplicatorForgetsOldKeys(t *testing.T) {
+	dedup := newAlertDeduplicator(time.Hour)
+	now := time.Now()
+	dedup.record([]string{"default/old"}, now.Add(-2*time.Hour))
+	dedup.record([]string{"default/new"}, now)
+
+	if _, ok := dedup.sent["default/old"]; ok {
+		t.Error("key older than the interval was not pruned")
+	}
+	if dedup.allow("default/new", now) {
+		t.Error("recently sent key is allowed again")
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// BusinessHours - рабочее время, по которому строятся рекомендации: часы [StartHour, EndHour)
+// в дни недели с FirstDay по LastDay (0 - воскресенье, 1 - понедельник, ..., 6 - суббота),
+// в часовом поясе со смещением UTCOffsetHours от UTC.
+type BusinessHours struct {
+	StartHour      int
+	EndHour        int
+	FirstDay       int
+	LastDay        int
+	UTCOffsetHours int
+}
+
+// validate проверяет границы рабочего времени
+func (bh BusinessHours) validate() error {
+	if bh.StartHour < 0 || bh.EndHour > 24 || bh.StartHour >= bh.EndHour {
+		return fmt.Errorf("BusinessHours hours must satisfy 0 <= StartHour < EndHour <= 24, got %d-%d", bh.StartHour, bh.EndHour)
+	}
+	if bh.FirstDay < 0 || bh.LastDay > 6 || bh.FirstDay > bh.LastDay {
+		return fmt.Errorf("BusinessHours days must satisfy 0 <= FirstDay <= LastDay <= 6, got %d-%d", bh.FirstDay, bh.LastDay)
+	}
+	if bh.UTCOffsetHours < -12 || bh.UTCOffsetHours > 14 {
+		return fmt.Errorf("BusinessHours UTCOffsetHours must be in [-12, 14], got %d", bh.UTCOffsetHours)
+	}
+	return nil
+}
+
+// businessHoursExpr оставляет в выражении только точки рабочего времени. hour() и day_of_week()
+// без аргумента берут время вычисления, поэтому внутри подзапроса и range-запроса фильтр
+// применяется к каждой точке ряда, и ночи с выходными не занижают рекомендацию.
+func (ma *MetricsAnalyzer) businessHoursExpr(expr string) string {
+	bh := ma.config.BusinessHours
+	if bh == nil {
+		return expr
+	}
+	now := fmt.Sprintf("vector(time() + %d)", bh.UTCOffsetHours*3600)
+	return fmt.Sprintf("(%s) and on() (hour(%s) >= %d < %d) and on() (day_of_week(%s) >= %d <= %d)",
+		expr, now, bh.StartHour, bh.EndHour, now, bh.FirstDay, bh.LastDay)
+}
+
+// contains сообщает, попадает ли момент t в рабочее время. Повторяет businessHoursExpr для
+// рядов, которые уже получены из Prometheus и фильтруются на стороне анализатора.
+func (bh BusinessHours) contains(t time.Time) bool {
+	local := t.UTC().Add(time.Duration(bh.UTCOffsetHours) * time.Hour)
+	day := int(local.Weekday())
+	return local.Hour() >= bh.StartHour && local.Hour() < bh.EndHour && day >= bh.FirstDay && day <= bh.LastDay
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+type ClusterInfo struct {
+	Context string `json:"context"`
+	Cluster string `json:"cluster"`
+	Current bool   `json:"current"`
+}
+
+// clusterRegistry держит по анализатору на каждый контекст kubeconfig. Анализатор текущего
+// контекста создается при старте, остальные - при первом обращении к ним через ?cluster=
+type clusterRegistry struct {
+	config   Config
+	current  string
+	clusters []ClusterInfo
+	// Клиенты Prometheus по адресу. Контексты с одним адресом делят клиента, чтобы ограничение
+	// частоты запросов к одному Prometheus было общим.
+	promClients map[string]v1.API
+
+	mu        sync.Mutex
+	analyzers map[string]*clusterEntry
+}
+
+// clusterEntry позволяет подключаться к новому контексту, не блокируя запросы к уже подключенным
+type clusterEntry struct {
+	once     sync.Once
+	analyzer *MetricsAnalyzer
+	err      error
+}
+
+func newClusterRegistry(config Config) (*clusterRegistry, error) {
+	registry := &clusterRegistry{
+		config:      config,
+		promClients: make(map[string]v1.API),
+		analyzers:   make(map[string]*clusterEntry),
+	}
+	prometheusURLs := []string{config.PrometheusURL}
+	for _, prometheusURL := range config.ContextPrometheusURLs {
+		prometheusURLs = append(prometheusURLs, prometheusURL)
+	}
+	for _, prometheusURL := range prometheusURLs {
+		if _, ok := registry.promClients[prometheusURL]; ok {
+			continue
+		}
+		prometheusConfig := config
+		prometheusConfig.PrometheusURL = prometheusURL
+		promClient, err := newPrometheusAPI(prometheusConfig)
+		if err != nil {
+			return nil, err
+		}
+		registry.promClients[prometheusURL] = promClient
+	}
+
+	// Без kubeconfig анализатор работает внутри кластера с единственным безымянным контекстом
+	if config.KubeconfigPath != "" {
+		raw, err := clientcmd.LoadFromFile(config.KubeconfigPath)
+		if err != nil {
+			return nil, err
+		}
+		registry.current = raw.CurrentContext
+		for name, kubeContext := range raw.Contexts {
+			registry.clusters = append(registry.clusters, ClusterInfo{
+				Context: name,
+				Cluster: kubeContext.Cluster,
+				Current: name == raw.CurrentContext,
+			})
+		}
+		sort.Slice(registry.clusters, func(i, j int) bool {
+			return registry.clusters[i].Context < registry.clusters[j].Context
+		})
+	}
+
+	if _, err := registry.get(""); err != nil {
+		return nil, err
+	}
+	return registry, nil
+}
+
+func (cr *clusterRegistry) list() []ClusterInfo {
+	return cr.clusters
+}
+
+// get возвращает анализатор для контекста, пустое имя - текущий контекст kubeconfig
+func (cr *clusterRegistry) get(contextName string) (*MetricsAnalyzer, error) {
+	if contextName == "" {
+		contextName = cr.current
+	}
+
+	if contextName != cr.current && !cr.hasContext(contextName) {
+		return nil, fmt.Errorf("unknown cluster context %q", contextName)
+	}
+	promClient, err := cr.promClient(contextName)
+	if err != nil {
+		return nil, err
+	}
+
+	cr.mu.Lock()
+	entry, ok := cr.analyzers[contextName]
+	if !ok {
+		entry = &clusterEntry{}
+		cr.analyzers[contextName] = entry
+	}
+	cr.mu.Unlock()
+
+	entry.once.Do(func() {
+		log.Printf("Connecting to cluster context %q", contextName)
+		entry.analyzer, entry.err = NewMetricsAnalyzer(cr.config, contextName, promClient)
+	})
+	if entry.err != nil {
+		// Не кешируем ошибку подключения, следующий запрос попробует снова
+		cr.mu.Lock()
+		if cr.analyzers[contextName] == entry {
+			delete(cr.analyzers, contextName)
+		}
+		cr.mu.Unlock()
+		return nil, entry.err
+	}
+	return entry.analyzer, nil
+}
+
+// promClient возвращает клиент Prometheus контекста: из ContextPrometheusURLs, а для текущего
+// контекста - PrometheusURL
+func (cr *clusterRegistry) promClient(contextName string) (v1.API, error) {
+	prometheusURL, ok := cr.config.ContextPrometheusURLs[contextName]
+	if !ok {
+		if contextName != cr.current {
+			return nil, fmt.Errorf("no Prometheus configured for cluster context %q in ContextPrometheusURLs", contextName)
+		}
+		prometheusURL = cr.config.PrometheusURL
+	}
+	return cr.promClients[prometheusURL], nil
+}
+
+func (cr *clusterRegistry) hasContext(contextName string) bool {
+	for _, cluster := range cr.clusters {
+		if cluster.Context == contextName {
+			return true
+		}
+	}
+	return false
+}
+
+// handle оборачивает обработчик, выбирая анализатор по параметру ?cluster= (контекст kubeconfig)
+// и проверяя доступ пользователя из заголовков имперсонации к namespace из scope.
+// nil scope - namespace известны только из тела запроса, и доступ проверяет сам обработчик.
+func (cr *clusterRegistry) handle(scope accessScope, handler func(*MetricsAnalyzer, http.ResponseWriter, *http.Request)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		analyzer, err := cr.get(r.URL.Query().Get("cluster"))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error selecting cluster: %v", err), http.StatusBadRequest)
+			return
+		}
+		if scope != nil {
+			if err := analyzer.authorize(r, scope(r)); err != nil {
+				writeAuthorizationError(w, err)
+				return
+			}
+		}
+		handler(analyzer, w, r)
+	}
+}
+
+// writeAuthorizationError отвечает 403, если доступ запрещен, и 500, если его не удалось проверить
+func writeAuthorizationError(w http.ResponseWriter, err error) {
+	if apierrors.IsForbidden(err) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
+
+// buildKubeConfig строит конфигурацию клиента для контекста kubeconfig.
+// Пустой контекст - текущий контекст, пустой путь - конфигурация внутри кластера.
+func buildKubeConfig(kubeconfigPath, contextName string) (*rest.Config, error) {
+	if kubeconfigPath == "" || contextName == "" {
+		return clientcmd.BuildConfigFromFlags("", kubeconfigPath)
+	}
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		&clientcmd.ClientConfigLoadingRules{ExplicitPath: kubeconfigPath},
+		&clientcmd.ConfigOverrides{CurrentContext: contextName},
+	).ClientConfig()
+}
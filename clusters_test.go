@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+)
+
+func TestClusterRegistryPrometheusPerContext(t *testing.T) {
+	config := testConfig()
+	config.PrometheusURL = "http://prometheus.prod:9090"
+	config.ContextPrometheusURLs = map[string]string{"stage": "http://prometheus.stage:9090"}
+	prod, stage := &seriesPrometheus{}, &seriesPrometheus{}
+	registry := &clusterRegistry{
+		config:      config,
+		current:     "prod",
+		promClients: map[string]v1.API{config.PrometheusURL: prod, config.ContextPrometheusURLs["stage"]: stage},
+	}
+
+	tests := []struct {
+		context string
+		want    v1.API
+	}{
+		{"prod", prod},
+		{"stage", stage},
+	}
+	for _, tt := range tests {
+		got, err := registry.promClient(tt.context)
+		if err != nil {
+			t.Fatalf("promClient(%q): %v", tt.context, err)
+		}
+		if got != tt.want {
+			t.Errorf("promClient(%q) returned the Prometheus of another context", tt.context)
+		}
+	}
+
+	// Без своего Prometheus контекст получил бы метрики текущего кластера
+	if _, err := registry.promClient("dev"); err == nil {
+		t.Error("promClient(\"dev\") succeeded without a Prometheus URL for the context")
+	}
+}
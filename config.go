@@ -0,0 +1,162 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+)
+
+// Validate проверяет конфигурацию при старте и возвращает сразу все найденные проблемы,
+// чтобы неверные значения не всплывали позже ошибками в обработчиках
+func (c Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	if c.FakeData != nil {
+		if err := c.FakeData.validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if c.PrometheusURL == "" {
+		errs = append(errs, errors.New("PrometheusURL is required"))
+	} else if _, err := url.ParseRequestURI(c.PrometheusURL); err != nil {
+		errs = append(errs, fmt.Errorf("PrometheusURL is invalid: %w", err))
+	}
+	for contextName, prometheusURL := range c.ContextPrometheusURLs {
+		if _, err := url.ParseRequestURI(prometheusURL); err != nil {
+			errs = append(errs, fmt.Errorf("ContextPrometheusURLs[%q] is invalid: %w", contextName, err))
+		}
+	}
+	if c.AlertWebhookURL != "" {
+		if _, err := url.ParseRequestURI(c.AlertWebhookURL); err != nil {
+			errs = append(errs, fmt.Errorf("AlertWebhookURL is invalid: %w", err))
+		}
+	}
+	if c.SharedCacheURL != "" {
+		if _, err := url.ParseRequestURI(c.SharedCacheURL); err != nil {
+			errs = append(errs, fmt.Errorf("SharedCacheURL is invalid: %w", err))
+		}
+	}
+	check(c.CPUUsageMetric != "", "CPUUsageMetric is required")
+	check(c.MemoryUsageMetric != "", "MemoryUsageMetric is required")
+	check(c.NetworkTransmitMetric != "", "NetworkTransmitMetric is required")
+	check(c.NodeMemoryTotalMetric != "", "NodeMemoryTotalMetric is required")
+	check(c.NodeMemoryAvailableMetric != "", "NodeMemoryAvailableMetric is required")
+	check(c.VolumeUsedMetric != "", "VolumeUsedMetric is required")
+	check(c.VolumeCapacityMetric != "", "VolumeCapacityMetric is required")
+	if c.ExcludeContainerRegex != "" {
+		if _, err := regexp.Compile(c.ExcludeContainerRegex); err != nil {
+			errs = append(errs, fmt.Errorf("ExcludeContainerRegex is invalid: %w", err))
+		}
+	}
+
+	check(c.CPUCostPerCore >= 0, "CPUCostPerCore must not be negative, got %g", c.CPUCostPerCore)
+	check(c.MemoryCostPerMB >= 0, "MemoryCostPerMB must not be negative, got %g", c.MemoryCostPerMB)
+	check(c.StorageCostPerGB >= 0, "StorageCostPerGB must not be negative, got %g", c.StorageCostPerGB)
+	check(c.EgressCostPerGB >= 0, "EgressCostPerGB must not be negative, got %g", c.EgressCostPerGB)
+	check(c.ClusterOverheadFactor >= 1, "ClusterOverheadFactor must be at least 1, got %g", c.ClusterOverheadFactor)
+	check(c.Currency == "" || len(c.Currency) == 3, "Currency must be an ISO 4217 code, got %q", c.Currency)
+
+	check(c.BatchWorkers > 0, "BatchWorkers must be positive, got %d", c.BatchWorkers)
+	check(c.MaxRequestBodyBytes > 0, "MaxRequestBodyBytes must be positive, got %d", c.MaxRequestBodyBytes)
+
+	switch c.RecommendationMode {
+	case RecommendationModeMax, RecommendationModeP95, RecommendationModeDecay:
+	default:
+		errs = append(errs, fmt.Errorf("RecommendationMode must be one of max, p95, decay, got %q", c.RecommendationMode))
+	}
+	switch c.PodAggregation {
+	case "", PodAggregationSum, PodAggregationMax:
+	default:
+		errs = append(errs, fmt.Errorf("PodAggregation must be sum or max, got %q", c.PodAggregation))
+	}
+	switch c.RecommendationDirection {
+	case "", RecommendationDirectionBoth, RecommendationDirectionIncrease, RecommendationDirectionDecrease:
+	default:
+		errs = append(errs, fmt.Errorf("RecommendationDirection must be one of both, increase_only, decrease_only, got %q", c.RecommendationDirection))
+	}
+	for _, resource := range c.RecommendResources {
+		check(resource == "cpu" || resource == "memory" || resource == "storage",
+			"RecommendResources entries must be cpu, memory or storage, got %q", resource)
+	}
+	check(c.HistoryWindow > 0, "HistoryWindow must be positive, got %v", c.HistoryWindow)
+	check(c.QueryStep > 0, "QueryStep must be positive, got %v", c.QueryStep)
+	check(c.QueryStep <= c.HistoryWindow, "QueryStep %v must not exceed HistoryWindow %v", c.QueryStep, c.HistoryWindow)
+	for _, window := range c.RecommendationWindows {
+		check(window >= c.QueryStep, "RecommendationWindows entry %v must not be shorter than QueryStep %v", window, c.QueryStep)
+	}
+	if c.BusinessHours != nil {
+		if err := c.BusinessHours.validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	check(c.RecommendationMode != RecommendationModeDecay || c.DecayHalfLife > 0, "DecayHalfLife must be positive in decay mode, got %v", c.DecayHalfLife)
+
+	check(c.CPURoundingMillicores >= 0, "CPURoundingMillicores must not be negative, got %d", c.CPURoundingMillicores)
+	check(c.CPULimitRequestRatio == 0 || c.CPULimitRequestRatio >= 1,
+		"CPULimitRequestRatio must be 0 (p99 only) or at least 1, got %g", c.CPULimitRequestRatio)
+	check(c.MemoryRoundingMB >= 0, "MemoryRoundingMB must not be negative, got %g", c.MemoryRoundingMB)
+	check(c.MemoryAbsoluteBuffer >= 0, "MemoryAbsoluteBuffer must not be negative, got %g", c.MemoryAbsoluteBuffer)
+	check(c.MaxMemoryLimitRequestRatio == 0 || c.MaxMemoryLimitRequestRatio >= 1,
+		"MaxMemoryLimitRequestRatio must be 0 (disabled) or at least 1, got %g", c.MaxMemoryLimitRequestRatio)
+	check(c.ReplicaAggregationPercentile > 0 && c.ReplicaAggregationPercentile <= 1,
+		"ReplicaAggregationPercentile must be in (0, 1], got %g", c.ReplicaAggregationPercentile)
+	check(c.PVCUnderutilizedPercent >= 0 && c.PVCUnderutilizedPercent <= 100,
+		"PVCUnderutilizedPercent must be in [0, 100], got %g", c.PVCUnderutilizedPercent)
+
+	check(c.PriorityCriticalSavings >= c.PriorityHighSavings && c.PriorityHighSavings >= c.PriorityMediumSavings && c.PriorityMediumSavings >= 0,
+		"priority savings thresholds must satisfy critical >= high >= medium >= 0")
+	check(c.MinAnalyzableCPU >= 0 && c.MinAnalyzableMemory >= 0, "MinAnalyzableCPU and MinAnalyzableMemory must not be negative")
+	check(c.NodePressureLowPercent <= c.NodePressureHighPercent,
+		"NodePressureLowPercent %g must not exceed NodePressureHighPercent %g", c.NodePressureLowPercent, c.NodePressureHighPercent)
+	for level, headroom := range c.NodePressureMemoryHeadroom {
+		check(headroom >= 1, "NodePressureMemoryHeadroom[%s] must be at least 1, got %g", level, headroom)
+	}
+	// Чем сильнее загружен узел, тем опаснее ужимать его поды, поэтому запас не убывает с загрузкой
+	check(c.memoryHeadroom(NodePressureLow) <= c.memoryHeadroom(NodePressureNormal) &&
+		c.memoryHeadroom(NodePressureNormal) <= c.memoryHeadroom(NodePressureHigh),
+		"NodePressureMemoryHeadroom must not decrease with pressure, got low %g, normal %g, high %g",
+		c.memoryHeadroom(NodePressureLow), c.memoryHeadroom(NodePressureNormal), c.memoryHeadroom(NodePressureHigh))
+	for kind, headroom := range c.HeadroomByKind {
+		check(headroom >= 1, "HeadroomByKind[%s] must be at least 1, got %g", kind, headroom)
+	}
+	check(c.CPUScoreWeight >= 0 && c.MemoryScoreWeight >= 0, "score weights must not be negative")
+	check(c.MemoryGrowthThreshold >= 0, "MemoryGrowthThreshold must not be negative, got %g", c.MemoryGrowthThreshold)
+
+	check(c.PrometheusQPS >= 0, "PrometheusQPS must not be negative, got %g", c.PrometheusQPS)
+	check(c.PrometheusBurst >= 0, "PrometheusBurst must not be negative, got %d", c.PrometheusBurst)
+	check(c.PrometheusQueryJitter >= 0, "PrometheusQueryJitter must not be negative, got %v", c.PrometheusQueryJitter)
+	check(c.KubernetesQPS >= 0 && c.KubernetesBurst >= 0, "KubernetesQPS and KubernetesBurst must not be negative")
+	check(c.KubernetesMaxIdleConnsPerHost >= 0 && c.PrometheusMaxIdleConnsPerHost >= 0, "MaxIdleConnsPerHost must not be negative")
+	check(c.KubernetesThrottleRetries >= 0, "KubernetesThrottleRetries must not be negative, got %d", c.KubernetesThrottleRetries)
+	check(c.ImpersonateUser != "" || len(c.ImpersonateGroups) == 0, "ImpersonateGroups require ImpersonateUser")
+	check(c.IdleConnTimeout >= 0, "IdleConnTimeout must not be negative, got %v", c.IdleConnTimeout)
+	check(c.PrometheusRetention >= 0, "PrometheusRetention must not be negative, got %v", c.PrometheusRetention)
+	check(c.InformerResyncPeriod >= 0, "InformerResyncPeriod must not be negative, got %v", c.InformerResyncPeriod)
+	check(c.InformerSyncTimeout >= 0, "InformerSyncTimeout must not be negative, got %v", c.InformerSyncTimeout)
+	check(c.AlertDedupInterval >= 0, "AlertDedupInterval must not be negative, got %v", c.AlertDedupInterval)
+	check(c.MaxPodsInResponse >= 0, "MaxPodsInResponse must not be negative, got %d", c.MaxPodsInResponse)
+	check(c.StatsCacheTTL >= 0, "StatsCacheTTL must not be negative, got %v", c.StatsCacheTTL)
+	check(c.ScanTimeout >= 0, "ScanTimeout must not be negative, got %v", c.ScanTimeout)
+	check(c.AlertClusterSavingsThreshold >= 0 && c.AlertPodSavingsThreshold >= 0, "alert thresholds must not be negative")
+
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		errs = append(errs, errors.New("both TLS certificate and key files must be set to enable TLS"))
+	}
+	for _, path := range []string{c.TLSCertFile, c.TLSKeyFile} {
+		if path == "" {
+			continue
+		}
+		if _, err := os.Stat(path); err != nil {
+			errs = append(errs, fmt.Errorf("TLS file is not accessible: %w", err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/kubernetes"
+)
+
+// PodEvent - событие Kubernetes по поду: OOMKilled, FailedScheduling, Evicted и т.п.
+type PodEvent struct {
+	Type      string    `json:"type"` // Normal или Warning
+	Reason    string    `json:"reason"`
+	Message   string    `json:"message"`
+	Count     int32     `json:"count"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// PodEventsResult объединяет рекомендацию по поду с тем, что с ним происходит в кластере
+type PodEventsResult struct {
+	Metrics PodMetrics `json:"metrics"`
+	Events  []PodEvent `json:"events"`
+}
+
+// getPodEvents возвращает события пода, начиная с последних. События не кешируются информерами:
+// их много и они быстро истекают, поэтому читаются напрямую из API-сервера клиентом client.
+func (ma *MetricsAnalyzer) getPodEvents(client kubernetes.Interface, namespace, podName string) ([]PodEvent, error) {
+	selector := fields.SelectorFromSet(fields.Set{
+		"involvedObject.kind": "Pod",
+		"involvedObject.name": podName,
+	})
+	ctx := context.Background()
+	list, err := client.CoreV1().Events(namespace).List(ctx, metav1.ListOptions{
+		FieldSelector: selector.String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]PodEvent, 0, len(list.Items))
+	for _, event := range list.Items {
+		events = append(events, newPodEvent(event))
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].LastSeen.After(events[j].LastSeen)
+	})
+	return events, nil
+}
+
+// newPodEvent приводит событие к единому виду: события events.k8s.io/v1 заполняют
+// EventTime и Series вместо FirstTimestamp, LastTimestamp и Count
+func newPodEvent(event corev1.Event) PodEvent {
+	result := PodEvent{
+		Type:      event.Type,
+		Reason:    event.Reason,
+		Message:   event.Message,
+		Count:     event.Count,
+		FirstSeen: event.FirstTimestamp.Time,
+		LastSeen:  event.LastTimestamp.Time,
+	}
+	if result.FirstSeen.IsZero() {
+		result.FirstSeen = event.EventTime.Time
+	}
+	if result.FirstSeen.IsZero() {
+		result.FirstSeen = event.CreationTimestamp.Time
+	}
+	if event.Series != nil {
+		result.Count = event.Series.Count
+		if result.LastSeen.IsZero() {
+			result.LastSeen = event.Series.LastObservedTime.Time
+		}
+	}
+	if result.LastSeen.IsZero() {
+		result.LastSeen = result.FirstSeen
+	}
+	if result.Count == 0 {
+		result.Count = 1
+	}
+	return result
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Content-Type текстового формата экспозиции Prometheus
+const expositionContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+type podGauge struct {
+	name  string
+	help  string
+	value func(PodMetrics) float64
+	// Стоимостные метрики получают метку currency: валюта задается в конфигурации,
+	// поэтому в имени метрики ее нет
+	cost bool
+}
+
+// writeRecommendationsExposition пишет рекомендации по подам в текстовом формате экспозиции Prometheus,
+// чтобы их можно было собирать обратно в Prometheus для алертов и долгосрочного хранения
+func (ma *MetricsAnalyzer) writeRecommendationsExposition(w io.Writer, stats ClusterStats) {
+	gauges := []podGauge{
+		{"magnittech_pod_current_cpu_millicores", "Current CPU of the pod in millicores.", func(m PodMetrics) float64 { return m.CurrentCPU * 1000 }, false},
+		{"magnittech_pod_recommended_cpu_millicores", "Recommended CPU of the pod in millicores.", func(m PodMetrics) float64 { return m.RecommendCPU * 1000 }, false},
+		{"magnittech_pod_current_memory_bytes", "Current memory of the pod in bytes.", func(m PodMetrics) float64 { return m.CurrentMemory }, false},
+		{"magnittech_pod_recommended_memory_bytes", "Recommended memory of the pod in bytes.", func(m PodMetrics) float64 { return m.RecommendMem }, false},
+		{"magnittech_pod_optimization_score", "Optimization score of the pod, higher means more overprovisioned.", func(m PodMetrics) float64 { return m.OptimizationScore }, false},
+		{"magnittech_pod_potential_savings", "Savings from applying the recommendation to the pod, in the currency label.", ma.podSavings, true},
+	}
+
+	currency := labelValueEscaper.Replace(ma.config.Currency)
+	for _, gauge := range gauges {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", gauge.name, gauge.help, gauge.name)
+		for _, pod := range stats.Pods {
+			labels := fmt.Sprintf("namespace=\"%s\",pod=\"%s\"", labelValueEscaper.Replace(pod.Namespace), labelValueEscaper.Replace(pod.PodName))
+			if gauge.cost {
+				labels += ",currency=\"" + currency + "\""
+			}
+			fmt.Fprintf(w, "%s{%s} %g\n", gauge.name, labels, gauge.value(pod))
+		}
+	}
+
+	fmt.Fprintf(w, "# HELP magnittech_cluster_potential_savings Savings from applying all recommendations, in the currency label.\n")
+	fmt.Fprintf(w, "# TYPE magnittech_cluster_potential_savings gauge\n")
+	fmt.Fprintf(w, "magnittech_cluster_potential_savings{currency=\"%s\"} %g\n", currency, stats.PotentialSavings)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRecommendationsExpositionCurrencyLabel(t *testing.T) {
+	config := testConfig()
+	config.Currency = "USD"
+	analyzer := &MetricsAnalyzer{config: config}
+
+	var buf bytes.Buffer
+	analyzer.writeRecommendationsExposition(&buf, ClusterStats{
+		PotentialSavings: 1500,
+		Pods: []PodMetrics{{
+			PodName:       "web-0",
+			Namespace:     "shop",
+			CurrentCPU:    1,
+			RecommendCPU:  0.5,
+			CurrentMemory: 1024 * 1024 * 1024,
+			RecommendMem:  1024 * 1024 * 1024,
+		}},
+	})
+	output := buf.String()
+
+	for _, want := range []string{
+		`magnittech_pod_potential_savings{namespace="shop",pod="web-0",currency="USD"} 500`,
+		`magnittech_cluster_potential_savings{currency="USD"} 1500`,
+		`magnittech_pod_recommended_cpu_millicores{namespace="shop",pod="web-0"} 500`,
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("exposition does not contain %q:\n%s", want, output)
+		}
+	}
+	if strings.Contains(output, "_rub") {
+		t.Errorf("metric names must not hardcode a currency:\n%s", output)
+	}
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// FakeDataConfig включает демонстрационный режим: вместо Prometheus и Kubernetes анализатор
+// работает с синтетическим кластером, поэтому фронтенд и CI могут проверять ответы всех
+// эндпоинтов без настоящего окружения. Одинаковый Seed дает одинаковый кластер.
+type FakeDataConfig struct {
+	Pods       int
+	Namespaces int
+	Seed       int64
+
+	// Пиковое использование пода равномерно распределено между долями его ресурсов
+	MinUtilization float64
+	MaxUtilization float64
+}
+
+// validate проверяет параметры синтетического кластера
+func (fd FakeDataConfig) validate() error {
+	if fd.Pods <= 0 || fd.Namespaces <= 0 {
+		return fmt.Errorf("FakeData Pods and Namespaces must be positive, got %d and %d", fd.Pods, fd.Namespaces)
+	}
+	if fd.MinUtilization < 0 || fd.MinUtilization > fd.MaxUtilization {
+		return fmt.Errorf("FakeData utilization must satisfy 0 <= MinUtilization <= MaxUtilization, got %g-%g", fd.MinUtilization, fd.MaxUtilization)
+	}
+	return nil
+}
+
+const fakeNodeCount = 3
+
+// fakeUsage - пиковое использование синтетического пода
+type fakeUsage struct {
+	cpu    float64 // Ядра
+	memory float64 // Байты
+	egress float64 // Байт/с
+}
+
+// fakeCluster - синтетические объекты Kubernetes и использование ресурсов подами
+type fakeCluster struct {
+	objects   []runtime.Object
+	usage     map[string]fakeUsage // По ключу namespace/pod
+	nodeUsage map[string]float64   // Использованная память узла в байтах
+}
+
+var (
+	fakeCPUChoices    = []string{"100m", "250m", "500m", "1", "2"}
+	fakeMemoryChoices = []string{"128Mi", "256Mi", "512Mi", "1Gi", "2Gi"}
+)
+
+// generateFakeCluster строит кластер из Deployment с 1-3 репликами, равномерно разложенных
+// по namespace и узлам. Генерация детерминирована: клиент Kubernetes и Prometheus строят
+// кластер независимо и получают одинаковые данные.
+func generateFakeCluster(cfg FakeDataConfig) *fakeCluster {
+	rnd := rand.New(rand.NewSource(cfg.Seed))
+	cluster := &fakeCluster{
+		usage:     make(map[string]fakeUsage),
+		nodeUsage: make(map[string]float64),
+	}
+	started := metav1.NewTime(time.Now().Add(-30 * 24 * time.Hour))
+
+	for i := 0; i < fakeNodeCount; i++ {
+		name := fmt.Sprintf("fake-node-%d", i)
+		capacity := resource.MustParse("32Gi")
+		cluster.objects = append(cluster.objects, &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Status: corev1.NodeStatus{
+				Capacity: corev1.ResourceList{
+					corev1.ResourceCPU:    resource.MustParse("16"),
+					corev1.ResourceMemory: capacity,
+				},
+				Allocatable: corev1.ResourceList{
+					corev1.ResourceCPU:    resource.MustParse("15500m"),
+					corev1.ResourceMemory: resource.MustParse("31Gi"),
+				},
+			},
+		})
+		cluster.nodeUsage[name] = capacity.AsApproximateFloat64() * (0.3 + 0.6*rnd.Float64())
+	}
+	for i := 0; i < cfg.Namespaces; i++ {
+		cluster.objects = append(cluster.objects, &corev1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("fake-ns-%d", i)},
+		})
+	}
+
+	for pods, d := 0, 0; pods < cfg.Pods; d++ {
+		namespace := fmt.Sprintf("fake-ns-%d", d%cfg.Namespaces)
+		name := fmt.Sprintf("fake-app-%d", d)
+		replicas := int32(1 + rnd.Intn(3))
+		if remaining := int32(cfg.Pods - pods); replicas > remaining {
+			replicas = remaining
+		}
+		pods += int(replicas)
+
+		cpu := resource.MustParse(fakeCPUChoices[rnd.Intn(len(fakeCPUChoices))])
+		memory := resource.MustParse(fakeMemoryChoices[rnd.Intn(len(fakeMemoryChoices))])
+		container := corev1.Container{
+			Name:  "app",
+			Image: fmt.Sprintf("registry.example.com/%s:1.0.%d", name, rnd.Intn(10)),
+			Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceCPU: cpu, corev1.ResourceMemory: memory},
+				Limits:   corev1.ResourceList{corev1.ResourceCPU: cpu, corev1.ResourceMemory: memory},
+			},
+		}
+		labels := map[string]string{"app": name, "team": fmt.Sprintf("team-%d", d%4)}
+		template := corev1.PodTemplateSpec{
+			ObjectMeta: metav1.ObjectMeta{Labels: labels},
+			Spec:       corev1.PodSpec{Containers: []corev1.Container{container}},
+		}
+
+		deployment := &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, UID: fakeUID(namespace, name)},
+			Spec: appsv1.DeploymentSpec{
+				Replicas: &replicas,
+				Selector: &metav1.LabelSelector{MatchLabels: labels},
+				Template: template,
+			},
+		}
+		replicaSetName := name + "-5d8f7c9b4"
+		replicaSet := &appsv1.ReplicaSet{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            replicaSetName,
+				Namespace:       namespace,
+				UID:             fakeUID(namespace, replicaSetName),
+				OwnerReferences: []metav1.OwnerReference{fakeControllerRef("Deployment", name, deployment.UID)},
+			},
+			Spec: appsv1.ReplicaSetSpec{
+				Replicas: &replicas,
+				Selector: &metav1.LabelSelector{MatchLabels: labels},
+				Template: template,
+			},
+		}
+		cluster.objects = append(cluster.objects, deployment, replicaSet)
+
+		// Реплики одной нагрузки используют ресурсы похоже, но не одинаково
+		cpuShare := cfg.MinUtilization + (cfg.MaxUtilization-cfg.MinUtilization)*rnd.Float64()
+		memoryShare := cfg.MinUtilization + (cfg.MaxUtilization-cfg.MinUtilization)*rnd.Float64()
+		for r := int32(0); r < replicas; r++ {
+			podName := fmt.Sprintf("%s-%d", replicaSetName, r)
+			cluster.objects = append(cluster.objects, &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:              podName,
+					Namespace:         namespace,
+					Labels:            labels,
+					CreationTimestamp: started,
+					OwnerReferences:   []metav1.OwnerReference{fakeControllerRef("ReplicaSet", replicaSetName, replicaSet.UID)},
+				},
+				Spec: corev1.PodSpec{
+					NodeName:   fmt.Sprintf("fake-node-%d", rnd.Intn(fakeNodeCount)),
+					Containers: []corev1.Container{container},
+				},
+				Status: corev1.PodStatus{Phase: corev1.PodRunning, StartTime: &started},
+			})
+			jitter := 0.9 + 0.2*rnd.Float64()
+			cluster.usage[namespace+"/"+podName] = fakeUsage{
+				cpu:    cpu.AsApproximateFloat64() * cpuShare * jitter,
+				memory: memory.AsApproximateFloat64() * memoryShare * jitter,
+				egress: 1024 * (1 + 1023*rnd.Float64()),
+			}
+		}
+	}
+	return cluster
+}
+
+func fakeUID(namespace, name string) types.UID {
+	return types.UID(namespace + "/" + name)
+}
+
+func fakeControllerRef(kind, name string, uid types.UID) metav1.OwnerReference {
+	controller := true
+	return metav1.OwnerReference{APIVersion: "apps/v1", Kind: kind, Name: name, UID: uid, Controller: &controller}
+}
+
+// newFakeKubernetesClient возвращает клиент Kubernetes, работающий с синтетическим кластером в памяти
+func newFakeKubernetesClient(cfg FakeDataConfig) kubernetes.Interface {
+	return fake.NewSimpleClientset(generateFakeCluster(cfg).objects...)
+}
+
+// fakePrometheusAPI отвечает на запросы анализатора значениями синтетического кластера.
+// Реализованы только методы, которые использует анализатор, остальные вызывают панику.
+type fakePrometheusAPI struct {
+	v1.API
+	config       Config
+	cluster      *fakeCluster
+	nodeSelector *regexp.Regexp // Имя узла в запросе загрузки памяти узла
+}
+
+func newFakePrometheusAPI(config Config) v1.API {
+	return &fakePrometheusAPI{
+		config:       config,
+		cluster:      generateFakeCluster(*config.FakeData),
+		nodeSelector: regexp.MustCompile(regexp.QuoteMeta(config.NodeMemoryTotalMetric) + `\{[^=]+="([^"]+)"\}`),
+	}
+}
+
+var (
+	fakePodSelector      = regexp.MustCompile(`pod="([^"]+)",namespace="([^"]+)"`)
+	fakeQuantileFunction = regexp.MustCompile(`^quantile_over_time\(([0-9.]+),`)
+)
+
+// value оценивает запрос по пиковому использованию пода или узла, которого он касается.
+// Статистики ряда (среднее, разброс, перцентили) выводятся из пика постоянными долями.
+func (fp *fakePrometheusAPI) value(query string) float64 {
+	var peak float64
+	if match := fp.nodeSelector.FindStringSubmatch(query); match != nil {
+		peak = fp.cluster.nodeUsage[match[1]]
+	} else if match := fakePodSelector.FindStringSubmatch(query); match != nil {
+		usage := fp.cluster.usage[match[2]+"/"+match[1]]
+		switch {
+		case strings.Contains(query, fp.config.NetworkTransmitMetric):
+			peak = usage.egress
+		case strings.Contains(query, fp.config.MemoryUsageMetric):
+			peak = usage.memory
+		case strings.Contains(query, fp.config.CPUUsageMetric):
+			peak = usage.cpu * 100 // Запросы CPU возвращают проценты ядра
+		}
+	}
+
+	switch {
+	case strings.HasPrefix(query, "count_over_time("):
+		return float64(fp.config.HistoryWindow / fp.config.QueryStep)
+	case strings.HasPrefix(query, "deriv("):
+		return 0
+	case strings.HasPrefix(query, "stddev_over_time("):
+		return peak * 0.2
+	case strings.HasPrefix(query, "avg_over_time("):
+		return peak * 0.6
+	}
+	if match := fakeQuantileFunction.FindStringSubmatch(query); match != nil {
+		quantile, _ := strconv.ParseFloat(match[1], 64)
+		return peak * quantile
+	}
+	return peak
+}
+
+func (fp *fakePrometheusAPI) Query(ctx context.Context, query string, ts time.Time, opts ...v1.Option) (model.Value, v1.Warnings, error) {
+	// Запросы по PVC группируются по меткам, а PVC в синтетическом кластере нет
+	if strings.Contains(query, fp.config.VolumeUsedMetric) || strings.Contains(query, fp.config.VolumeCapacityMetric) {
+		return model.Vector{}, nil, nil
+	}
+	// Запросы по контейнерам группируются по метке container, в синтетических подах контейнер один
+	var metric model.Metric
+	if strings.Contains(query, "by (container)") {
+		metric = model.Metric{"container": "app"}
+	}
+	return model.Vector{&model.Sample{
+		Metric:    metric,
+		Value:     model.SampleValue(fp.value(query)),
+		Timestamp: model.TimeFromUnixNano(ts.UnixNano()),
+	}}, nil, nil
+}
+
+func (fp *fakePrometheusAPI) QueryRange(ctx context.Context, query string, r v1.Range, opts ...v1.Option) (model.Value, v1.Warnings, error) {
+	value := model.SampleValue(fp.value(query))
+	series := &model.SampleStream{}
+	for ts := r.Start; !ts.After(r.End); ts = ts.Add(r.Step) {
+		series.Values = append(series.Values, model.SamplePair{Timestamp: model.TimeFromUnixNano(ts.UnixNano()), Value: value})
+	}
+	return model.Matrix{series}, nil, nil
+}
+
+func (fp *fakePrometheusAPI) Runtimeinfo(ctx context.Context) (v1.RuntimeinfoResult, error) {
+	return v1.RuntimeinfoResult{StorageRetention: "90d"}, nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// GroupMetrics - метрики и стоимость подов, отобранных селектором меток по всем namespace,
+// например всех подов команды team=checkout. Итоги группы - поля ClusterStats, разбивка по подам - Pods.
+type GroupMetrics struct {
+	Selector   string   `json:"selector"`
+	Namespaces []string `json:"namespaces"` // Namespace, в которых нашлись поды группы
+
+	// Стоимость текущих и рекомендуемых ресурсов группы
+	CurrentCost     float64 `json:"current_cost"`
+	RecommendedCost float64 `json:"recommended_cost"`
+
+	ClusterStats
+}
+
+// parseGroupSelector разбирает селектор группы в синтаксисе kubectl -l. Пустой селектор
+// совпал бы со всеми подами кластера, поэтому он не допускается.
+func parseGroupSelector(value string) (labels.Selector, error) {
+	if value == "" {
+		return nil, fmt.Errorf("selector is required, e.g. team=checkout")
+	}
+	selector, err := labels.Parse(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid selector %q: %w", value, err)
+	}
+	return selector, nil
+}
+
+// getGroupMetrics сканирует поды группы во всех namespace и сводит их метрики и стоимость
+func (ma *MetricsAnalyzer) getGroupMetrics(selector labels.Selector, opts ScanOptions) (GroupMetrics, error) {
+	opts.Namespace, opts.NamespaceLimit, opts.Cursor = "", 0, ""
+	opts.LabelSelector = selector
+	stats, err := ma.getClusterStats(opts)
+	if err != nil {
+		return GroupMetrics{}, err
+	}
+
+	group := GroupMetrics{Selector: selector.String(), Namespaces: []string{}, ClusterStats: stats}
+	seen := make(map[string]bool)
+	for _, pod := range stats.Pods {
+		if !seen[pod.Namespace] {
+			seen[pod.Namespace] = true
+			group.Namespaces = append(group.Namespaces, pod.Namespace)
+		}
+	}
+	sort.Strings(group.Namespaces)
+
+	group.CurrentCost = ma.resourceCost(stats.TotalCurrentCPU, stats.TotalCurrentMemory)
+	group.RecommendedCost = ma.resourceCost(stats.TotalRecommendCPU, stats.TotalRecommendMem)
+	return group, nil
+}
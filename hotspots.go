@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+// Количество контейнеров в ответе /api/hotspots по умолчанию
+const defaultHotspotsLimit = 20
+
+// ContainerHotspot - рекомендация для отдельного контейнера. Рекомендация пода прячет контейнер,
+// который держит основной запас, за соседями, поэтому для чистки удобнее искать по контейнерам.
+type ContainerHotspot struct {
+	Namespace         string  `json:"namespace"`
+	PodName           string  `json:"pod_name"`
+	Container         string  `json:"container"`
+	CurrentCPU        float64 `json:"current_cpu"`    // Ядра, лимит или запрос
+	CurrentMemory     float64 `json:"current_memory"` // Байты, лимит или запрос
+	MaxCPU            float64 `json:"max_cpu"`        // Пиковое использование в процентах ядра
+	MaxMemory         float64 `json:"max_memory"`     // Пиковое использование в байтах
+	RecommendCPU      float64 `json:"recommend_cpu"`
+	RecommendMem      float64 `json:"recommend_memory"`
+	OptimizationScore float64 `json:"optimization_score"`
+	PotentialSavings  float64 `json:"potential_savings"`
+}
+
+// getHotspots возвращает limit контейнеров кластера с наибольшей экономией. Контейнеры берутся
+// из подов последнего полного сканирования, поэтому фильтры и исключения сканирования действуют
+// и здесь, а использование каждого контейнера запрашивается отдельно.
+func (ma *MetricsAnalyzer) getHotspots(limit int) ([]ContainerHotspot, error) {
+	stats, err := ma.getCachedClusterStats()
+	if err != nil {
+		return nil, err
+	}
+
+	workers := ma.config.BatchWorkers
+	if workers <= 0 {
+		workers = 1
+	}
+	sem := make(chan struct{}, workers)
+
+	var mu sync.Mutex
+	var hotspots []ContainerHotspot
+	var wg sync.WaitGroup
+	for _, podMetrics := range stats.Pods {
+		wg.Add(1)
+		go func(podMetrics PodMetrics) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			containers, err := ma.getContainerHotspots(podMetrics.PodName, podMetrics.Namespace)
+			if err != nil {
+				log.Printf("Error getting container metrics for pod %s in namespace %s: %v", podMetrics.PodName, podMetrics.Namespace, err)
+				return
+			}
+			mu.Lock()
+			hotspots = append(hotspots, containers...)
+			mu.Unlock()
+		}(podMetrics)
+	}
+	wg.Wait()
+
+	sort.Slice(hotspots, func(i, j int) bool {
+		if hotspots[i].PotentialSavings != hotspots[j].PotentialSavings {
+			return hotspots[i].PotentialSavings > hotspots[j].PotentialSavings
+		}
+		return hotspots[i].Namespace+"/"+hotspots[i].PodName+"/"+hotspots[i].Container <
+			hotspots[j].Namespace+"/"+hotspots[j].PodName+"/"+hotspots[j].Container
+	})
+	if limit > 0 && len(hotspots) > limit {
+		hotspots = hotspots[:limit]
+	}
+	return hotspots, nil
+}
+
+// getContainerHotspots рассчитывает рекомендации для анализируемых контейнеров пода по их
+// пиковому использованию за HistoryWindow, с запасом памяти рабочей нагрузки пода
+func (ma *MetricsAnalyzer) getContainerHotspots(podName, namespace string) ([]ContainerHotspot, error) {
+	pod, err := ma.getPod(namespace, podName)
+	if err != nil {
+		return nil, err
+	}
+
+	qlog := &queryLog{}
+	selector := ma.containerSelector(podName, namespace)
+	maxCPU, err := ma.queryByContainer(ma.containerPeakExpr(`sum by (container) (rate(`+ma.config.CPUUsageMetric+`{`+selector+`}[5m]) * 100)`, qlog), qlog)
+	if err != nil {
+		return nil, err
+	}
+	maxMemory, err := ma.queryByContainer(ma.containerPeakExpr(`sum by (container) (`+ma.config.MemoryUsageMetric+`{`+selector+`})`, qlog), qlog)
+	if err != nil {
+		return nil, err
+	}
+
+	kind, _ := ma.resolveOwner(pod)
+	headroom := ma.workloadMemoryHeadroom(kind, "")
+	var hotspots []ContainerHotspot
+	for _, container := range pod.Spec.Containers {
+		if ma.isContainerExcluded(container.Name) {
+			continue
+		}
+		currentCPU, currentMemory := containerResources(container)
+		rec := ma.recommend(currentCPU, currentMemory, maxCPU[container.Name], maxMemory[container.Name], headroom)
+		rec = ma.keepDisabledResources(rec, currentCPU, currentMemory)
+		hotspot := ContainerHotspot{
+			Namespace:         namespace,
+			PodName:           podName,
+			Container:         container.Name,
+			CurrentCPU:        currentCPU,
+			CurrentMemory:     currentMemory,
+			MaxCPU:            maxCPU[container.Name],
+			MaxMemory:         maxMemory[container.Name],
+			RecommendCPU:      rec.CPU,
+			RecommendMem:      rec.Memory,
+			OptimizationScore: rec.Score,
+		}
+		hotspot.PotentialSavings = ma.resourceCost(currentCPU-rec.CPU, currentMemory-rec.Memory)
+		hotspots = append(hotspots, hotspot)
+	}
+	return hotspots, nil
+}
+
+// containerPeakExpr - пик выражения по контейнерам за HistoryWindow в рабочее время
+func (ma *MetricsAnalyzer) containerPeakExpr(expr string, qlog *queryLog) string {
+	window := model.Duration(ma.clampWindow(ma.config.HistoryWindow, qlog)).String()
+	step := model.Duration(ma.config.QueryStep).String()
+	return fmt.Sprintf("max_over_time((%s)[%s:%s])", ma.businessHoursExpr(expr), window, step)
+}
+
+// queryByContainer выполняет запрос и возвращает значения по метке container
+func (ma *MetricsAnalyzer) queryByContainer(query string, qlog *queryLog) (map[string]float64, error) {
+	result, queryWarns, err := ma.promClient.Query(context.Background(), query, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	qlog.add(query, result, queryWarns)
+
+	values := make(map[string]float64)
+	if vector, ok := result.(model.Vector); ok {
+		for _, sample := range vector {
+			values[string(sample.Metric["container"])] += float64(sample.Value)
+		}
+	}
+	return values, nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// Анализатор может обращаться к API Kubernetes от имени другого пользователя, чтобы права
+// проверялись по RBAC этого пользователя, а в аудите API-сервера был виден он, а не сервисный аккаунт.
+//
+// ImpersonateUser и ImpersonateGroups из конфигурации действуют на все запросы анализатора,
+// включая информеры. Заголовки Impersonate-User и Impersonate-Group входящего запроса действуют
+// на прямые запросы к API (события пода). Данные подов, узлов и рабочих нагрузок читаются
+// из общего кеша информеров, который от пользователя не зависит, поэтому перед ответом
+// SubjectAccessReview проверяет, что пользователь из заголовков может читать поды в namespace
+// запроса, а для запросов по всему кластеру - во всех namespace. Заголовкам можно доверять,
+// только если их выставляет аутентифицирующий прокси перед анализатором, поэтому они
+// учитываются лишь при TrustImpersonationHeaders.
+
+// impersonationConfig возвращает настройку имперсонации из конфигурации
+func (c Config) impersonationConfig() rest.ImpersonationConfig {
+	return rest.ImpersonationConfig{UserName: c.ImpersonateUser, Groups: c.ImpersonateGroups}
+}
+
+// impersonationFromRequest читает пользователя и группы из заголовков запроса
+func impersonationFromRequest(r *http.Request) (rest.ImpersonationConfig, bool) {
+	user := r.Header.Get("Impersonate-User")
+	if user == "" {
+		return rest.ImpersonationConfig{}, false
+	}
+	return rest.ImpersonationConfig{UserName: user, Groups: r.Header.Values("Impersonate-Group")}, true
+}
+
+// clientForRequest возвращает клиент Kubernetes для прямых запросов в рамках входящего запроса:
+// от имени пользователя из заголовков, если им разрешено доверять, иначе клиент анализатора
+func (ma *MetricsAnalyzer) clientForRequest(r *http.Request) (kubernetes.Interface, error) {
+	impersonate, ok := impersonationFromRequest(r)
+	if !ok || !ma.config.TrustImpersonationHeaders || ma.k8sConfig == nil {
+		return ma.k8sClient, nil
+	}
+	k8sConfig := rest.CopyConfig(ma.k8sConfig)
+	k8sConfig.Impersonate = impersonate
+	return kubernetes.NewForConfig(k8sConfig)
+}
+
+// accessScope возвращает namespace, данные которых читает запрос. Пустая строка - весь кластер.
+type accessScope func(r *http.Request) string
+
+// namespaceScope - namespace из параметра ?namespace=, а без него defaultNamespace
+func namespaceScope(defaultNamespace string) accessScope {
+	return func(r *http.Request) string {
+		if namespace := r.URL.Query().Get("namespace"); namespace != "" {
+			return namespace
+		}
+		return defaultNamespace
+	}
+}
+
+// clusterScope - запрос читает данные всего кластера независимо от параметров
+func clusterScope(*http.Request) string {
+	return ""
+}
+
+// authorize проверяет, что пользователь из заголовков имперсонации может читать поды в каждом
+// из namespace (пустая строка - во всем кластере). Без доверенных заголовков запрос выполняется
+// с правами анализатора, и проверка не нужна.
+func (ma *MetricsAnalyzer) authorize(r *http.Request, namespaces ...string) error {
+	impersonate, ok := impersonationFromRequest(r)
+	if !ok || !ma.config.TrustImpersonationHeaders {
+		return nil
+	}
+
+	checked := make(map[string]bool)
+	for _, namespace := range namespaces {
+		if checked[namespace] {
+			continue
+		}
+		checked[namespace] = true
+
+		review, err := ma.k8sClient.AuthorizationV1().SubjectAccessReviews().Create(r.Context(), &authorizationv1.SubjectAccessReview{
+			Spec: authorizationv1.SubjectAccessReviewSpec{
+				User:   impersonate.UserName,
+				Groups: impersonate.Groups,
+				ResourceAttributes: &authorizationv1.ResourceAttributes{
+					Namespace: namespace,
+					Verb:      "list",
+					Resource:  "pods",
+				},
+			},
+		}, metav1.CreateOptions{})
+		if err != nil {
+			return fmt.Errorf("error checking access for user %q: %w", impersonate.UserName, err)
+		}
+		if !review.Status.Allowed {
+			scope := "namespace " + namespace
+			if namespace == "" {
+				scope = "all namespaces"
+			}
+			return apierrors.NewForbidden(schema.GroupResource{Resource: "pods"}, "",
+				fmt.Errorf("user %q cannot list pods in %s", impersonate.UserName, scope))
+		}
+	}
+	return nil
+}
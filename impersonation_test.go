@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// newAccessReviewClient возвращает клиент, который разрешает пользователю alice list pods только в namespace team-a
+func newAccessReviewClient() *fake.Clientset {
+	client := fake.NewSimpleClientset()
+	client.PrependReactor("create", "subjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SubjectAccessReview)
+		attrs := review.Spec.ResourceAttributes
+		review.Status.Allowed = review.Spec.User == "alice" && attrs.Namespace == "team-a" &&
+			attrs.Verb == "list" && attrs.Resource == "pods"
+		return true, review, nil
+	})
+	return client
+}
+
+func impersonatedRequest(target string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, target, nil)
+	r.Header.Set("Impersonate-User", "alice")
+	return r
+}
+
+func TestAuthorizeImpersonatedUser(t *testing.T) {
+	analyzer := &MetricsAnalyzer{k8sClient: newAccessReviewClient(), config: Config{TrustImpersonationHeaders: true}}
+
+	if err := analyzer.authorize(impersonatedRequest("/api/cluster-stats?namespace=team-a"), "team-a"); err != nil {
+		t.Fatalf("expected access to team-a, got %v", err)
+	}
+	if err := analyzer.authorize(impersonatedRequest("/api/cluster-stats?namespace=team-b"), "team-b"); !apierrors.IsForbidden(err) {
+		t.Fatalf("expected forbidden for team-b, got %v", err)
+	}
+	if err := analyzer.authorize(impersonatedRequest("/api/cluster-stats"), ""); !apierrors.IsForbidden(err) {
+		t.Fatalf("expected forbidden for a cluster-wide request, got %v", err)
+	}
+	if err := analyzer.authorize(impersonatedRequest("/api/metrics/batch"), "team-a", "team-b"); !apierrors.IsForbidden(err) {
+		t.Fatalf("expected forbidden when one of the namespaces is denied, got %v", err)
+	}
+}
+
+func TestAuthorizeIgnoresUntrustedHeaders(t *testing.T) {
+	client := newAccessReviewClient()
+	analyzer := &MetricsAnalyzer{k8sClient: client}
+
+	if err := analyzer.authorize(impersonatedRequest("/api/cluster-stats"), ""); err != nil {
+		t.Fatalf("untrusted headers must not be checked, got %v", err)
+	}
+	if len(client.Actions()) != 0 {
+		t.Fatalf("expected no access reviews, got %d", len(client.Actions()))
+	}
+}
+
+func TestNamespaceScope(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/api/metrics", nil)
+	if got := namespaceScope("default")(r); got != "default" {
+		t.Errorf("namespaceScope without ?namespace= = %q, want default", got)
+	}
+	r = httptest.NewRequest(http.MethodGet, "/api/metrics?namespace=team-a", nil)
+	if got := namespaceScope("default")(r); got != "team-a" {
+		t.Errorf("namespaceScope = %q, want team-a", got)
+	}
+	if got := clusterScope(r); got != "" {
+		t.Errorf("clusterScope = %q, want the whole cluster", got)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+)
+
+// startInformers запускает общие информеры и дожидается синхронизации их кешей не дольше
+// InformerSyncTimeout. Если кеши не синхронизировались, информеры останавливаются.
+// Все чтения объектов кластера идут из этих кешей, напрямую в API-сервер обращаются только записи.
+func (ma *MetricsAnalyzer) startInformers() error {
+	factory := informers.NewSharedInformerFactory(ma.k8sClient, ma.config.InformerResyncPeriod)
+
+	ma.namespaceLister = factory.Core().V1().Namespaces().Lister()
+	ma.podLister = factory.Core().V1().Pods().Lister()
+	ma.deploymentLister = factory.Apps().V1().Deployments().Lister()
+	ma.statefulSetLister = factory.Apps().V1().StatefulSets().Lister()
+	ma.replicaSetLister = factory.Apps().V1().ReplicaSets().Lister()
+	ma.pvcLister = factory.Core().V1().PersistentVolumeClaims().Lister()
+	ma.nodeLister = factory.Core().V1().Nodes().Lister()
+	ma.limitRangeLister = factory.Core().V1().LimitRanges().Lister()
+
+	ma.stopCh = make(chan struct{})
+	factory.Start(ma.stopCh)
+
+	ctx := context.Background()
+	if ma.config.InformerSyncTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, ma.config.InformerSyncTimeout)
+		defer cancel()
+	}
+	for informerType, synced := range factory.WaitForCacheSync(ctx.Done()) {
+		if !synced {
+			close(ma.stopCh)
+			factory.Shutdown()
+			return fmt.Errorf("failed to sync informer cache for %v within %v", informerType, ma.config.InformerSyncTimeout)
+		}
+	}
+	return nil
+}
+
+// listNamespaces возвращает namespace кластера, отсортированные по имени
+func (ma *MetricsAnalyzer) listNamespaces() ([]*corev1.Namespace, error) {
+	namespaces, err := ma.namespaceLister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(namespaces, func(i, j int) bool {
+		return namespaces[i].Name < namespaces[j].Name
+	})
+	return namespaces, nil
+}
+
+// listPods возвращает поды namespace (пустая строка - все namespace), отсортированные по namespace и имени
+func (ma *MetricsAnalyzer) listPods(namespace string) ([]*corev1.Pod, error) {
+	var pods []*corev1.Pod
+	var err error
+	if namespace == "" {
+		pods, err = ma.podLister.List(labels.Everything())
+	} else {
+		pods, err = ma.podLister.Pods(namespace).List(labels.Everything())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(pods, func(i, j int) bool {
+		if pods[i].Namespace != pods[j].Namespace {
+			return pods[i].Namespace < pods[j].Namespace
+		}
+		return pods[i].Name < pods[j].Name
+	})
+	return pods, nil
+}
+
+func (ma *MetricsAnalyzer) getPod(namespace, name string) (*corev1.Pod, error) {
+	return ma.podLister.Pods(namespace).Get(name)
+}
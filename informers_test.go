@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+func TestStartInformersTimesOut(t *testing.T) {
+	unblock := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-unblock
+	}))
+	defer server.Close()
+	defer close(unblock)
+
+	client, err := kubernetes.NewForConfig(&rest.Config{Host: server.URL})
+	if err != nil {
+		t.Fatalf("NewForConfig: %v", err)
+	}
+	analyzer := &MetricsAnalyzer{k8sClient: client, config: Config{InformerSyncTimeout: 100 * time.Millisecond}}
+
+	done := make(chan error, 1)
+	go func() { done <- analyzer.startInformers() }()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("expected a sync error from an unresponsive API server")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("startInformers did not honor InformerSyncTimeout")
+	}
+
+	select {
+	case <-analyzer.stopCh:
+	default:
+		t.Fatal("informers must be stopped after a failed sync")
+	}
+}
+
+// pagingAPIServer - API-сервер, который отдает поды страницами по pageSize с токеном Continue,
+// даже если клиент не задал limit, а остальные списки - пустыми. Watch-запросы висят до отключения клиента.
+type pagingAPIServer struct {
+	pods      []corev1.Pod
+	pageSize  int
+	mu        sync.Mutex
+	continues []string // Токены Continue, с которыми клиент запрашивал следующие страницы
+}
+
+func (s *pagingAPIServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.URL.Query().Get("watch") == "true" {
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+		return
+	}
+
+	var list runtime.Object
+	switch r.URL.Path {
+	case "/api/v1/pods":
+		start := 0
+		if token := r.URL.Query().Get("continue"); token != "" {
+			s.mu.Lock()
+			s.continues = append(s.continues, token)
+			s.mu.Unlock()
+			start, _ = strconv.Atoi(token)
+		}
+		end := min(start+s.pageSize, len(s.pods))
+		pods := &corev1.PodList{TypeMeta: metav1.TypeMeta{Kind: "PodList", APIVersion: "v1"}, Items: s.pods[start:end]}
+		pods.ResourceVersion = "1"
+		if end < len(s.pods) {
+			pods.Continue = strconv.Itoa(end)
+		}
+		list = pods
+	case "/api/v1/namespaces":
+		list = &corev1.NamespaceList{TypeMeta: metav1.TypeMeta{Kind: "NamespaceList", APIVersion: "v1"}}
+	case "/api/v1/nodes":
+		list = &corev1.NodeList{TypeMeta: metav1.TypeMeta{Kind: "NodeList", APIVersion: "v1"}}
+	case "/api/v1/persistentvolumeclaims":
+		list = &corev1.PersistentVolumeClaimList{TypeMeta: metav1.TypeMeta{Kind: "PersistentVolumeClaimList", APIVersion: "v1"}}
+	case "/api/v1/limitranges":
+		list = &corev1.LimitRangeList{TypeMeta: metav1.TypeMeta{Kind: "LimitRangeList", APIVersion: "v1"}}
+	case "/apis/apps/v1/deployments":
+		list = &appsv1.DeploymentList{TypeMeta: metav1.TypeMeta{Kind: "DeploymentList", APIVersion: "apps/v1"}}
+	case "/apis/apps/v1/statefulsets":
+		list = &appsv1.StatefulSetList{TypeMeta: metav1.TypeMeta{Kind: "StatefulSetList", APIVersion: "apps/v1"}}
+	case "/apis/apps/v1/replicasets":
+		list = &appsv1.ReplicaSetList{TypeMeta: metav1.TypeMeta{Kind: "ReplicaSetList", APIVersion: "apps/v1"}}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	json.NewEncoder(w).Encode(list)
+}
+
+func TestListPodsFollowsPages(t *testing.T) {
+	apiServer := &pagingAPIServer{pageSize: 2}
+	for i := 0; i < 5; i++ {
+		apiServer.pods = append(apiServer.pods, corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("pod-%d", i), Namespace: "default", ResourceVersion: "1"},
+		})
+	}
+	server := httptest.NewServer(apiServer)
+	defer server.Close()
+	defer server.CloseClientConnections()
+
+	client, err := kubernetes.NewForConfig(&rest.Config{Host: server.URL})
+	if err != nil {
+		t.Fatalf("NewForConfig: %v", err)
+	}
+	analyzer := &MetricsAnalyzer{k8sClient: client, config: Config{InformerSyncTimeout: 5 * time.Second}}
+	if err := analyzer.startInformers(); err != nil {
+		t.Fatalf("startInformers: %v", err)
+	}
+	defer close(analyzer.stopCh)
+
+	pods, err := analyzer.listPods("")
+	if err != nil {
+		t.Fatalf("listPods: %v", err)
+	}
+	if len(pods) != len(apiServer.pods) {
+		t.Fatalf("listPods returned %d pods, want %d from all pages", len(pods), len(apiServer.pods))
+	}
+	apiServer.mu.Lock()
+	defer apiServer.mu.Unlock()
+	if len(apiServer.continues) != 2 {
+		t.Errorf("expected 2 follow-up page requests, got %v", apiServer.continues)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+var updateSnapshots = flag.Bool("update", false, "перезаписать снимки JSON в testdata")
+
+// checkJSONSnapshot сравнивает сериализованное значение со снимком testdata/<name>.json.
+// Снимок фиксирует имена полей и то, какие нулевые поля опускаются, чтобы изменения
+// формата ответа не ломали клиентов незаметно. go test -update перезаписывает снимки.
+func checkJSONSnapshot(t *testing.T, name string, value any) {
+	t.Helper()
+	got, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		t.Fatalf("marshal %s: %v", name, err)
+	}
+	got = append(got, '\n')
+
+	path := filepath.Join("testdata", name+".json")
+	if *updateSnapshots {
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatalf("write snapshot: %v", err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read snapshot: %v", err)
+	}
+	if string(got) != string(want) {
+		t.Errorf("%s JSON changed, run go test -update if intended\ngot:\n%s\nwant:\n%s", name, got, want)
+	}
+}
+
+// newTestServer возвращает обработчики API поверх анализатора текущего контекста
+func newTestServer(t *testing.T, analyzer *MetricsAnalyzer) http.Handler {
+	t.Helper()
+	entry := &clusterEntry{analyzer: analyzer}
+	entry.once.Do(func() {})
+	registry := &clusterRegistry{
+		config:    analyzer.config,
+		analyzers: map[string]*clusterEntry{"": entry},
+	}
+	return newServeMux(analyzer.config, registry)
+}
+
+// getJSON выполняет GET к обработчику и возвращает разобранный ответ с отступами. Ответ
+// разбирается заново, чтобы снимок не зависел от форматирования кодировщика.
+func getJSON(t *testing.T, handler http.Handler, target string) any {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, target, nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("GET %s: status %d: %s", target, recorder.Code, recorder.Body)
+	}
+	var body any
+	if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+		t.Fatalf("GET %s: %v", target, err)
+	}
+	return body
+}
+
+// snapshotAnalyzer - кластер из одного избыточного пода с постоянным использованием
+func snapshotAnalyzer(t *testing.T, objects ...runtime.Object) *MetricsAnalyzer {
+	config := testConfig()
+	usage := map[string][]containerUsage{"shop/web-0": {{cpu: 25, memory: 256 * 1024 * 1024}}}
+	return newTestAnalyzer(t, config, &seriesPrometheus{config: config, containers: usage}, objects...)
+}
+
+func TestPodMetricsJSONSnapshot(t *testing.T) {
+	pod := testPod("shop", "web-0", time.Now().Add(-24*time.Hour), resources("1", "1Gi"))
+	server := newTestServer(t, snapshotAnalyzer(t, pod))
+	checkJSONSnapshot(t, "pod_metrics", getJSON(t, server, "/api/metrics?namespace=shop&pod-id=web-0"))
+}
+
+func TestClusterStatsJSONSnapshot(t *testing.T) {
+	namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "shop"}}
+	pod := testPod("shop", "web-0", time.Now().Add(-24*time.Hour), resources("1", "1Gi"))
+	server := newTestServer(t, snapshotAnalyzer(t, namespace, pod))
+	checkJSONSnapshot(t, "cluster_stats", getJSON(t, server, "/api/cluster-stats"))
+}
+
+// Пустой кластер отдает пустой список подов: клиенты рассчитывают, что поле pods есть всегда
+func TestClusterStatsJSONSnapshotEmptyCluster(t *testing.T) {
+	server := newTestServer(t, snapshotAnalyzer(t))
+	checkJSONSnapshot(t, "cluster_stats_empty", getJSON(t, server, "/api/cluster-stats"))
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+)
+
+// Группировка подов в /api/leaderboard
+const (
+	GroupByNamespace = "namespace"
+	GroupByTeam      = "team"
+	GroupByOwner     = "owner"
+)
+
+// Группа подов без метки команды
+const noTeamGroup = "<none>"
+
+// LeaderboardEntry - группа подов и экономия от применения ее рекомендаций
+type LeaderboardEntry struct {
+	Rank                int     `json:"rank"`
+	Group               string  `json:"group"`
+	Pods                int     `json:"pods"`
+	OverprovisionedPods int     `json:"overprovisioned_pods"`
+	PotentialSavings    float64 `json:"potential_savings"`
+	EffectiveSavings    float64 `json:"effective_savings"`
+}
+
+// groupByFromRequest читает параметр ?group_by=, по умолчанию - по namespace
+func groupByFromRequest(r *http.Request) (string, error) {
+	switch value := r.URL.Query().Get("group_by"); value {
+	case "", GroupByNamespace:
+		return GroupByNamespace, nil
+	case GroupByTeam, GroupByOwner:
+		return value, nil
+	default:
+		return "", fmt.Errorf("group_by must be namespace, team or owner, got %q", value)
+	}
+}
+
+// getLeaderboard группирует поды последнего полного сканирования по namespace, команде
+// (метка TeamLabel) или рабочей нагрузке и ранжирует группы по экономии
+func (ma *MetricsAnalyzer) getLeaderboard(groupBy string) ([]LeaderboardEntry, error) {
+	stats, err := ma.getCachedClusterStats()
+	if err != nil {
+		return nil, err
+	}
+
+	groups := make(map[string]*LeaderboardEntry)
+	for _, pod := range stats.Pods {
+		key := ma.leaderboardGroup(pod, groupBy)
+		entry, ok := groups[key]
+		if !ok {
+			entry = &LeaderboardEntry{Group: key}
+			groups[key] = entry
+		}
+		entry.Pods++
+		if isOverprovisioned(pod) {
+			entry.OverprovisionedPods++
+		}
+		entry.PotentialSavings += pod.PotentialSavings
+		entry.EffectiveSavings += pod.EffectiveSavings
+	}
+
+	entries := make([]LeaderboardEntry, 0, len(groups))
+	for _, entry := range groups {
+		entries = append(entries, *entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].PotentialSavings != entries[j].PotentialSavings {
+			return entries[i].PotentialSavings > entries[j].PotentialSavings
+		}
+		return entries[i].Group < entries[j].Group
+	})
+	for i := range entries {
+		entries[i].Rank = i + 1
+	}
+	return entries, nil
+}
+
+// leaderboardGroup возвращает группу пода. Метки и владелец читаются из кеша информера:
+// в метриках пода есть только метки из LabelAllowlist.
+func (ma *MetricsAnalyzer) leaderboardGroup(metrics PodMetrics, groupBy string) string {
+	if groupBy == GroupByNamespace {
+		return metrics.Namespace
+	}
+	pod, err := ma.getPod(metrics.Namespace, metrics.PodName)
+	if err != nil {
+		// Под удален после сканирования
+		if groupBy == GroupByTeam {
+			return noTeamGroup
+		}
+		return metrics.Namespace + "/Pod/" + metrics.PodName
+	}
+	if groupBy == GroupByTeam {
+		if team := pod.Labels[ma.config.TeamLabel]; team != "" {
+			return team
+		}
+		return noTeamGroup
+	}
+	kind, name := ma.resolveOwner(pod)
+	return metrics.Namespace + "/" + kind + "/" + name
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"math"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// ReasonLimitRangeClamped - рекомендация вышла за границы LimitRange namespace и была к ним прижата
+const ReasonLimitRangeClamped = "recommendation clamped to LimitRange"
+
+// limitRangeBounds - границы ресурсов пода по LimitRange его namespace, 0 - без границы
+type limitRangeBounds struct {
+	Min ResourceSize
+	Max ResourceSize
+}
+
+// podLimitRangeBounds сводит все LimitRange namespace пода в границы суммарных ресурсов пода.
+// Границы типа Pod применяются к сумме как есть. Рекомендация строится на под целиком, поэтому
+// границы типа Container переводятся в границы пода умножением на количество анализируемых
+// контейнеров - это точно для подов с одним контейнером и приближенно для остальных.
+// Из нескольких LimitRange берутся самые строгие границы.
+func (ma *MetricsAnalyzer) podLimitRangeBounds(pod *corev1.Pod) (limitRangeBounds, error) {
+	limitRanges, err := ma.limitRangeLister.LimitRanges(pod.Namespace).List(labels.Everything())
+	if err != nil {
+		return limitRangeBounds{}, err
+	}
+
+	containers := 0
+	for _, container := range pod.Spec.Containers {
+		if !ma.isContainerExcluded(container.Name) {
+			containers++
+		}
+	}
+
+	var bounds limitRangeBounds
+	for _, limitRange := range limitRanges {
+		for _, item := range limitRange.Spec.Limits {
+			var factor float64
+			switch item.Type {
+			case corev1.LimitTypePod:
+				factor = 1
+			case corev1.LimitTypeContainer:
+				factor = float64(containers)
+			default:
+				continue
+			}
+			bounds.Min.CPU = math.Max(bounds.Min.CPU, item.Min.Cpu().AsApproximateFloat64()*factor)
+			bounds.Min.Memory = math.Max(bounds.Min.Memory, item.Min.Memory().AsApproximateFloat64()*factor)
+			bounds.Max.CPU = tighterMax(bounds.Max.CPU, item.Max.Cpu().AsApproximateFloat64()*factor)
+			bounds.Max.Memory = tighterMax(bounds.Max.Memory, item.Max.Memory().AsApproximateFloat64()*factor)
+		}
+	}
+	return bounds, nil
+}
+
+// tighterMax возвращает более строгую из двух верхних границ, где 0 означает отсутствие границы
+func tighterMax(current, candidate float64) float64 {
+	if candidate <= 0 {
+		return current
+	}
+	if current <= 0 {
+		return candidate
+	}
+	return math.Min(current, candidate)
+}
+
+// clampToLimitRange прижимает рекомендацию к границам LimitRange, чтобы ее можно было применить:
+// значения вне границ API-сервер отклонит. Возвращает описание, если рекомендация изменилась.
+func (ma *MetricsAnalyzer) clampToLimitRange(rec recommendation, bounds limitRangeBounds, currentCPU, currentMemory float64) (recommendation, string) {
+	cpu, memory := clampBound(rec.CPU, bounds.Min.CPU, bounds.Max.CPU), clampBound(rec.Memory, bounds.Min.Memory, bounds.Max.Memory)
+	if cpu == rec.CPU && memory == rec.Memory {
+		return rec, ""
+	}
+	reason := fmt.Sprintf("%s: cpu %g -> %g, memory %.0f -> %.0f bytes", ReasonLimitRangeClamped, rec.CPU, cpu, rec.Memory, memory)
+	rec.CPU, rec.Memory = cpu, memory
+	ma.scoreRecommendation(&rec, currentCPU, currentMemory)
+	return rec, reason
+}
+
+func clampBound(value, min, max float64) float64 {
+	if max > 0 && value > max {
+		value = max
+	}
+	return math.Max(value, min)
+}
@@ -1,41 +1,334 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"log"
+	"math"
 	"net/http"
+	"net/http/pprof"
+	"os"
+	"regexp"
 	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
-	"github.com/prometheus/client_golang/api"
 	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
 	"github.com/prometheus/common/model"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/tools/clientcmd"
+	appslisters "k8s.io/client-go/listers/apps/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/rest"
 )
 
 type Config struct {
 	CPUCostPerCore  float64 // Стоимость одного ядра в рублях
 	MemoryCostPerMB float64 // Стоимость одного МБ памяти в рублях
-	PrometheusURL   string
+	Currency        string  // Код валюты ISO 4217 (RUB, USD), в которой заданы все цены и возвращаются стоимости
+	PrometheusURL   string  // Prometheus текущего контекста kubeconfig
 	KubeconfigPath  string
+
+	// Prometheus остальных контекстов kubeconfig по имени контекста. Метрики в запросах не отбираются
+	// по метке кластера, поэтому у каждого кластера должен быть свой Prometheus: контекст без записи
+	// (кроме текущего) недоступен через ?cluster=, иначе поды одноименных namespace разных
+	// кластеров смешались бы в одних метриках.
+	ContextPrometheusURLs map[string]string
+
+	BatchWorkers        int   // Количество параллельных запросов при пакетном анализе подов
+	MaxRequestBodyBytes int64 // Максимальный размер тела POST-запроса
+
+	// Имена метрик Prometheus, из которых строятся запросы. По умолчанию - метрики cAdvisor,
+	// node-exporter и kubelet, переопределяются для кластеров с альтернативными экспортерами.
+	CPUUsageMetric            string
+	MemoryUsageMetric         string
+	NetworkTransmitMetric     string // Исходящий трафик контейнера, байты
+	NodeMemoryTotalMetric     string
+	NodeMemoryAvailableMetric string
+	VolumeUsedMetric          string // Занятое место тома PVC, байты
+	VolumeCapacityMetric      string
+
+	// Регулярное выражение (в синтаксисе PromQL, якорится целиком) для имен контейнеров,
+	// которые не учитываются при анализе, например сайдкары service mesh. Пустая строка - не исключать ничего.
+	ExcludeContainerRegex string
+
+	// Агрегация рядов пода (по контейнерам, а у части экспортеров и по ядрам) в использование пода:
+	//   sum - суммарное использование пода, рекомендация на под целиком (по умолчанию);
+	//   max - использование самого нагруженного ряда. Занижает многоконтейнерные поды,
+	//         подходит, только если метрика уже содержит по одному ряду итогов на под.
+	PodAggregation string
+
+	// Режим расчета рекомендаций по истории использования:
+	//   max   - пиковое значение за окно HistoryWindow;
+	//   p95   - 95-й перцентиль за окно, отбрасывает редкие кратковременные всплески;
+	//   decay - пиковое значение, где каждая точка ряда умножается на 2^(-возраст/DecayHalfLife),
+	//           поэтому недавняя нагрузка весит больше старой и рекомендации быстрее
+	//           подстраиваются под изменение нагрузки.
+	RecommendationMode string
+	HistoryWindow      time.Duration // Окно истории, по которому считаются рекомендации
+	// Дополнительные окна истории, например 1h, 24h и 7d. Использование считается по каждому окну,
+	// и рекомендация строится по наибольшему, чтобы под, рассчитанный по тихому воскресенью,
+	// не упирался в лимиты в понедельник. Пустой список - только HistoryWindow.
+	RecommendationWindows []time.Duration
+	QueryStep             time.Duration // Шаг дискретизации ряда в подзапросах и range-запросах
+	DecayHalfLife         time.Duration // Период полураспада веса точки в режиме decay
+
+	// Учитывать в рекомендациях только рабочее время, например пн-пт 08:00-20:00 по Москве,
+	// чтобы ночи и выходные не занижали рекомендации для нагрузки, которая приходится на рабочий день.
+	// nil - круглосуточно.
+	BusinessHours *BusinessHours
+
+	// Допустимое направление изменения ресурсов в рекомендациях:
+	//   both          - рекомендации могут как увеличивать, так и уменьшать ресурсы;
+	//   increase_only - рекомендация не опускается ниже текущих значений (только устранение недовыделения);
+	//   decrease_only - рекомендация не поднимается выше текущих значений (только экономия).
+	RecommendationDirection string
+
+	// Ресурсы, по которым строятся рекомендации: cpu, memory, storage. Для остальных рекомендация
+	// равна текущему значению, например когда размер памяти определяется отдельным процессом.
+	// Пустой список - все ресурсы.
+	RecommendResources []string
+
+	// Шаг округления рекомендаций. Округление всегда вверх, чтобы не уменьшать запас. 0 - без округления
+	CPURoundingMillicores int64
+	MemoryRoundingMB      float64
+
+	// Лимит CPU рекомендуется не ниже запроса, умноженного на CPULimitRequestRatio, чтобы у пода
+	// оставался запас на всплески. 0 - лимит по 99-му перцентилю использования.
+	CPULimitRequestRatio float64
+
+	// Минимальный запас памяти над пиковым использованием в МБ. Множитель запаса дает маленьким подам
+	// запас в десятки мегабайт, который съедает одна крупная аллокация. 0 - только множитель.
+	MemoryAbsoluteBuffer float64
+
+	// Порог отношения лимита памяти к запросу, выше которого конфигурация контейнера считается подозрительной.
+	// 0 - не проверять отношение.
+	MaxMemoryLimitRequestRatio float64
+
+	// Перцентиль (0..1) пикового использования по репликам, по которому строится единая рекомендация для рабочей нагрузки
+	ReplicaAggregationPercentile float64
+
+	// Приоритет оптимизации пода. Под со score ниже PriorityMinScore или без экономии получает "ok",
+	// остальные распределяются по абсолютной экономии в рублях, чтобы крупный под с умеренным
+	// score оказался выше маленького, но почти простаивающего.
+	PriorityMinScore        float64
+	PriorityCriticalSavings float64
+	PriorityHighSavings     float64
+	PriorityMediumSavings   float64
+
+	// Поды, запросы которых меньше порогов, не анализируются при сканировании кластера:
+	// экономия на них ничтожна, а в отчете они создают шум. Если заданы оба порога, под должен
+	// быть меньше обоих. 0 - без порога по этому ресурсу.
+	MinAnalyzableCPU    float64 // В ядрах
+	MinAnalyzableMemory float64 // В МБ
+
+	// Учет загрузки узла: под на узле с нехваткой памяти опасно ужимать, поэтому запас растет
+	// с загрузкой, а на недогруженном узле всплеск покроет свободная память узла и запас меньше.
+	// Загрузка - использованная память узла (по node-exporter) в процентах от емкости узла;
+	// NodeMetricLabel - метка node-exporter с именем узла Kubernetes.
+	// Метка instance обычно содержит адрес и порт экспортера, а не имя узла, поэтому по умолчанию
+	// берется метка node, которую проставляет relabeling kube-prometheus-stack.
+	NodeMetricLabel            string
+	NodePressureLowPercent     float64
+	NodePressureHighPercent    float64
+	NodePressureMemoryHeadroom map[string]float64 // Запас к пиковой памяти по уровню загрузки (low, normal, high)
+
+	// Запас к пиковой памяти по типу рабочей нагрузки пода (Deployment, StatefulSet, DaemonSet, Job, Pod),
+	// например 1.5 для StatefulSet с базами данных. Типы без записи получают запас по загрузке узла.
+	HeadroomByKind map[string]float64
+
+	// Веса CPU и памяти в optimization_score. Переопределяются на запрос параметрами
+	// ?cpu_weight= и ?mem_weight= /api/cluster-stats без повторного сканирования.
+	CPUScoreWeight    float64
+	MemoryScoreWeight float64
+
+	// Максимальное количество подов в ответе /api/cluster-stats. На очень больших кластерах
+	// в ответ попадают только поды с наивысшим приоритетом, итоги по-прежнему считаются по всем. 0 - без ограничения.
+	MaxPodsInResponse int
+
+	// Сколько хранится результат последнего полного сканирования для пересчета score с другими весами
+	StatsCacheTTL time.Duration
+
+	// Адрес ведущей реплики анализатора, у которой ведомые реплики берут результат полного
+	// сканирования кластера вместо собственного. Пустая строка - реплика сканирует сама.
+	SharedCacheURL string
+
+	// Общий бюджет времени на сканирование кластера. По его истечении прерываются текущие запросы
+	// к Prometheus, оставшиеся поды не анализируются, и возвращается частичный результат, чтобы
+	// деградация Prometheus не растягивала запрос. 0 - без ограничения.
+	ScanTimeout time.Duration
+
+	// Доля пикового использования памяти, на которую она должна вырасти по тренду за HistoryWindow,
+	// чтобы под считался растущим (возможная утечка). 0 - не проверять тренд.
+	MemoryGrowthThreshold float64
+
+	// Ключи меток пода, которые попадают в ответ для группировки затрат (например, по команде)
+	LabelAllowlist []string
+
+	// Метка пода с именем команды для /api/leaderboard?group_by=team
+	TeamLabel string
+
+	StorageCostPerGB        float64 // Стоимость одного ГБ постоянного хранилища в рублях
+	PVCUnderutilizedPercent float64 // PVC с заполненностью ниже этого процента считаются избыточными
+	EgressCostPerGB         float64 // Стоимость одного ГБ исходящего сетевого трафика в рублях
+
+	// Накладные расходы кластера: системные демоны, kube-reserved и неидеальная упаковка подов
+	// по узлам. Освобожденные подами ресурсы не превращаются в снятые узлы один к одному,
+	// поэтому effective_savings = potential_savings / ClusterOverheadFactor. 1 - без поправки.
+	ClusterOverheadFactor float64
+
+	// Сертификат и ключ для HTTPS. Если заданы оба, сервер слушает по TLS
+	TLSCertFile string
+	TLSKeyFile  string
+
+	// Ограничение частоты запросов к Prometheus, общее для всех сканирований. PrometheusQPS 0 - без ограничения.
+	// Перед каждым запросом добавляется случайная задержка до PrometheusQueryJitter, чтобы сгладить пики.
+	PrometheusQPS         float64
+	PrometheusBurst       int
+	PrometheusQueryJitter time.Duration
+
+	// Клиент Kubernetes: ограничение частоты запросов (по умолчанию 5 в секунду с всплеском до 10, как в client-go) и пулы
+	// простаивающих соединений с API-сервером и Prometheus. 0 - значения библиотек по умолчанию.
+	// IdleConnTimeout - через сколько закрывается простаивающее соединение.
+	KubernetesQPS                 float32
+	KubernetesBurst               int
+	KubernetesMaxIdleConnsPerHost int
+	PrometheusMaxIdleConnsPerHost int
+	IdleConnTimeout               time.Duration
+
+	// Сколько раз повторять запрос к API Kubernetes, отклоненный с 429 Too Many Requests. 0 - не повторять.
+	KubernetesThrottleRetries int
+
+	// Срок хранения данных в Prometheus. Окна анализа длиннее него сокращаются до срока хранения
+	// с предупреждением в ответе. 0 - узнать срок из runtimeinfo Prometheus.
+	PrometheusRetention time.Duration
+
+	// Дополнительные параметры запросов к Prometheus-совместимому API. Для Thanos Query это,
+	// например, partial_response, dedup и max_source_resolution (auto, 5m, 1h), что позволяет
+	// строить рекомендации по окнам в 30 дней на прореженных данных.
+	PrometheusQueryParams map[string]string
+
+	// Имперсонация при обращении к API Kubernetes (см. impersonation.go): пользователь и группы,
+	// от имени которых работает анализатор, и доверие к заголовкам Impersonate-User/Impersonate-Group
+	// входящих запросов. Доверять заголовкам можно только за аутентифицирующим прокси.
+	ImpersonateUser           string
+	ImpersonateGroups         []string
+	TrustImpersonationHeaders bool
+
+	EnableProfiling bool // Обработчики net/http/pprof под /debug/pprof/ на основном сервере
+
+	// Синтетический кластер вместо Prometheus и Kubernetes для демонстрации и интеграционных тестов.
+	// nil - работа с настоящим кластером.
+	FakeData *FakeDataConfig
+
+	InformerResyncPeriod time.Duration // Период полной пересинхронизации кешей информеров, 0 - без пересинхронизации
+	// Сколько ждать первой синхронизации кешей информеров. Недоступный API-сервер иначе
+	// навсегда подвешивает старт и запросы с ?cluster=. 0 - без ограничения.
+	InformerSyncTimeout time.Duration
+
+	// Уведомления о потенциальной экономии после каждого сканирования кластера.
+	// Пустой AlertWebhookURL отключает уведомления, нулевой порог отключает соответствующую проверку.
+	AlertWebhookURL              string
+	AlertClusterSavingsThreshold float64       // Порог экономии по кластеру в рублях
+	AlertPodSavingsThreshold     float64       // Порог экономии по одному поду в рублях
+	AlertDedupInterval           time.Duration // Минимальный интервал между повторными уведомлениями по одному поду
 }
 
+const (
+	PodAggregationSum = "sum"
+	PodAggregationMax = "max"
+)
+
+const (
+	RecommendationModeMax   = "max"
+	RecommendationModeP95   = "p95"
+	RecommendationModeDecay = "decay"
+)
+
+const (
+	RecommendationDirectionBoth     = "both"
+	RecommendationDirectionIncrease = "increase_only"
+	RecommendationDirectionDecrease = "decrease_only"
+)
+
 type PodMetrics struct {
-	PodName           string  `json:"pod_name"`
-	Namespace         string  `json:"namespace"`
-	CurrentCPU        float64 `json:"current_cpu"`
-	CurrentMemory     float64 `json:"current_memory"`
-	MaxCPU            float64 `json:"max_cpu"`
-	MaxMemory         float64 `json:"max_memory"`
-	RecommendCPU      float64 `json:"recommend_cpu"`
-	RecommendMem      float64 `json:"recommend_memory"`
-	OptimizationScore float64 `json:"optimization_score"` // Чем выше, тем больше необходимость оптимизации
+	PodName            string             `json:"pod_name"`
+	Namespace          string             `json:"namespace"`
+	CurrentCPU         float64            `json:"current_cpu"`
+	CurrentMemory      float64            `json:"current_memory"`
+	MaxCPU             float64            `json:"max_cpu"`
+	MaxMemory          float64            `json:"max_memory"`
+	RecommendCPU       float64            `json:"recommend_cpu"`
+	RecommendMem       float64            `json:"recommend_memory"`
+	RawRecommendCPU    float64            `json:"raw_recommend_cpu"`    // Рекомендация до округления
+	RawRecommendMem    float64            `json:"raw_recommend_memory"` // Рекомендация до округления
+	CPUWindow          string             `json:"cpu_window"`           // Окно истории, по которому построена рекомендация CPU
+	MemoryWindow       string             `json:"memory_window"`        // Окно истории, по которому построена рекомендация памяти
+	OptimizationScore  float64            `json:"optimization_score"`   // Чем выше, тем больше необходимость оптимизации
+	Priority           string             `json:"priority"`             // critical, high, medium, low или ok
+	CPUScore           float64            `json:"cpu_score"`            // Составляющая optimization_score по CPU
+	MemoryScore        float64            `json:"memory_score"`         // Составляющая optimization_score по памяти
+	ExcludedContainers []string           `json:"excluded_containers,omitempty"`
+	CPUPercentiles     map[string]float64 `json:"cpu_percentiles,omitempty"`    // Распределение использования CPU за окно, в тех же единицах, что max_cpu
+	MemoryPercentiles  map[string]float64 `json:"memory_percentiles,omitempty"` // Распределение использования памяти за окно, в байтах
+	Reasons            []string           `json:"reasons,omitempty"`            // Найденные проблемы конфигурации ресурсов
+	Unbounded          bool               `json:"unbounded,omitempty"`          // Не заданы ни запросы, ни лимиты: рекомендуется их задать
+	Labels             map[string]string  `json:"labels,omitempty"`             // Метки пода из LabelAllowlist
+	Images             []string           `json:"images,omitempty"`
+	Confidence         float64            `json:"confidence"` // Доверие к рекомендации от 0 до 1
+
+	// Раздельные рекомендации CPU: запрос по 95-му перцентилю использования, лимит - пиковая
+	// recommend_cpu. Разница между ними оставляет контейнеру запас на всплески.
+	RecommendCPURequest float64 `json:"recommend_cpu_request"`
+	RecommendCPULimit   float64 `json:"recommend_cpu_limit"`
+
+	NetworkEgressBytes float64 `json:"network_egress_bytes,omitempty"` // Средний исходящий трафик за HistoryWindow, байт/с
+	NetworkEgressCost  float64 `json:"network_egress_cost,omitempty"`  // Стоимость исходящего трафика в рублях за 30 дней
+
+	Warnings []string `json:"warnings,omitempty"` // Предупреждения Prometheus, например о неполных данных
+	Currency string   `json:"currency"`           // Валюта стоимостей пода
+
+	Queries []QueryDebug `json:"queries,omitempty"` // Выполненные запросы PromQL с результатами при ?debug=true
+
+	Trend       string  `json:"trend,omitempty"`        // growing, если память устойчиво растет
+	MemorySlope float64 `json:"memory_slope,omitempty"` // Наклон линейной регрессии памяти за HistoryWindow, байт/с
+
+	NodeName           string  `json:"node_name,omitempty"`
+	NodeMemoryPressure float64 `json:"node_memory_pressure,omitempty"` // Использованная память узла в процентах от allocatable
+	NodePressure       string  `json:"node_pressure,omitempty"`        // low, normal или high
+
+	LimitRangeClamped bool `json:"limit_range_clamped,omitempty"` // Рекомендация прижата к границам LimitRange namespace
+
+	PotentialSavings float64 `json:"potential_savings"` // Экономия от применения рекомендации к поду
+	EffectiveSavings float64 `json:"effective_savings"` // potential_savings с учетом ClusterOverheadFactor
+
+	// Изменение рекомендации относительно текущих значений в процентах: -60 значит "уменьшить на 60%"
+	CPUChangePercent    float64 `json:"cpu_change_percent"`
+	MemoryChangePercent float64 `json:"memory_change_percent"`
 }
 
+const ReasonNoLimits = "no limits set"
+
+const (
+	ReasonMemoryGrowing = "memory usage is growing, possible leak"
+	TrendGrowing        = "growing"
+)
+
+const (
+	PriorityCritical = "critical"
+	PriorityHigh     = "high"
+	PriorityMedium   = "medium"
+	PriorityLow      = "low"
+	PriorityOK       = "ok"
+)
+
 type ClusterStats struct {
 	TotalPods          int          `json:"total_pods"`
 	TotalCurrentCPU    float64      `json:"total_current_cpu"`
@@ -45,86 +338,463 @@ type ClusterStats struct {
 	TotalRecommendCPU  float64      `json:"total_recommend_cpu"`
 	TotalRecommendMem  float64      `json:"total_recommend_memory"`
 	PotentialSavings   float64      `json:"potential_savings"`
+	CPUSavings         float64      `json:"cpu_savings"`    // Составляющая potential_savings по CPU
+	MemorySavings      float64      `json:"memory_savings"` // Составляющая potential_savings по памяти
 	Pods               []PodMetrics `json:"pods"`
+	SkippedPods        []PodRef     `json:"skipped_pods,omitempty"`       // Поды с аннотацией отказа от оптимизации, не учтены в итогах
+	SkippedSmallPods   int          `json:"skipped_small_pods,omitempty"` // Поды меньше порога MinAnalyzableCPU/MinAnalyzableMemory
+
+	ExcludedTerminatedPods int `json:"excluded_terminated_pods,omitempty"` // Поды в фазе Failed и вытесненные, без ?include_terminated=true
+
+	// Поды, которые не удалось проанализировать, и их ошибки, сгруппированные по причине
+	FailedPods int            `json:"failed_pods,omitempty"`
+	Errors     []ErrorSummary `json:"errors,omitempty"`
+
+	// Пиковое использование в процентах от запросов по всему кластеру. Поды без запроса ресурса
+	// не учитываются в проценте по этому ресурсу: их использование не с чем сравнивать.
+	CPUUtilizationPercent    float64 `json:"cpu_utilization_percent"`
+	MemoryUtilizationPercent float64 `json:"memory_utilization_percent"`
+
+	EffectiveSavings float64 `json:"effective_savings"` // potential_savings с учетом ClusterOverheadFactor
+
+	TotalNetworkEgressCost float64 `json:"total_network_egress_cost,omitempty"` // Рублей за 30 дней, в potential_savings не входит
+
+	Warnings []string `json:"warnings,omitempty"` // Предупреждения Prometheus по всем подам без повторов
+	Currency string   `json:"currency"`           // Валюта potential_savings и остальных стоимостей
+
+	// Рекомендации для рабочих нагрузок с несколькими репликами при ?collapse_replicas=true.
+	// Поды этих нагрузок в pods не повторяются, итоговые суммы по-прежнему считаются по подам.
+	Workloads []WorkloadRecommendation `json:"workloads,omitempty"`
+
+	// Сканирование прервано по ScanTimeout: итоги посчитаны только по обработанным подам
+	Partial         bool `json:"partial,omitempty"`
+	UnprocessedPods int  `json:"unprocessed_pods,omitempty"`
+
+	// Список pods сокращен до MaxPodsInResponse подов с наивысшим приоритетом из total_pods
+	Truncated bool `json:"truncated,omitempty"`
+
+	// Курсор следующей порции namespace при ?namespaces_per_scan=, пустой - просканированы все
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+type Misconfiguration struct {
+	PodName   string   `json:"pod_name"`
+	Namespace string   `json:"namespace"`
+	Reasons   []string `json:"reasons"`
+}
+
+type PodRef struct {
+	Namespace string `json:"namespace"`
+	PodName   string `json:"pod_name"`
+}
+
+type BatchMetricsRequest struct {
+	Pods []PodRef `json:"pods"`
+}
+
+type BatchMetricsResult struct {
+	Namespace string      `json:"namespace"`
+	PodName   string      `json:"pod_name"`
+	Metrics   *PodMetrics `json:"metrics,omitempty"`
+	Error     string      `json:"error,omitempty"`
 }
 
 type MetricsAnalyzer struct {
-	promClient v1.API
-	k8sClient  *kubernetes.Clientset
-	config     Config
+	promClient       v1.API
+	k8sClient        kubernetes.Interface
+	k8sConfig        *rest.Config // nil в демонстрационном режиме
+	config           Config
+	contextName      string // Контекст kubeconfig, пустая строка - текущий
+	excludeContainer *regexp.Regexp
+	alertDedup       *alertDeduplicator
+	statsCache       statsCache
+	retentionCache   retentionCache
+	scanMu           sync.Mutex // Не дает ведомым репликам запустить несколько полных сканирований разом
+	nodesWithoutData sync.Map   // Узлы без метрик node-exporter, о которых уже предупредили в логе
+
+	stopCh            chan struct{}
+	namespaceLister   corelisters.NamespaceLister
+	podLister         corelisters.PodLister
+	deploymentLister  appslisters.DeploymentLister
+	statefulSetLister appslisters.StatefulSetLister
+	replicaSetLister  appslisters.ReplicaSetLister
+	pvcLister         corelisters.PersistentVolumeClaimLister
+	nodeLister        corelisters.NodeLister
+	limitRangeLister  corelisters.LimitRangeLister
 }
 
-func NewMetricsAnalyzer(config Config) (*MetricsAnalyzer, error) {
-	promClient, err := api.NewClient(api.Config{
-		Address: config.PrometheusURL,
-	})
-	if err != nil {
-		return nil, err
+func NewMetricsAnalyzer(config Config, contextName string, promClient v1.API) (*MetricsAnalyzer, error) {
+	var k8sClient kubernetes.Interface
+	var k8sConfig *rest.Config
+	if config.FakeData != nil {
+		k8sClient = newFakeKubernetesClient(*config.FakeData)
+	} else {
+		var err error
+		k8sConfig, err = buildKubeConfig(config.KubeconfigPath, contextName)
+		if err != nil {
+			return nil, err
+		}
+		k8sConfig, err = tuneKubeConfig(k8sConfig, config)
+		if err != nil {
+			return nil, err
+		}
+		if config.ImpersonateUser != "" {
+			k8sConfig.Impersonate = config.impersonationConfig()
+		}
+		k8sClient, err = kubernetes.NewForConfig(k8sConfig)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	k8sConfig, err := clientcmd.BuildConfigFromFlags("", config.KubeconfigPath)
-	if err != nil {
-		return nil, err
+	var err error
+	var excludeContainer *regexp.Regexp
+	if config.ExcludeContainerRegex != "" {
+		// Prometheus якорит регулярные выражения целиком, делаем так же для согласованности
+		excludeContainer, err = regexp.Compile("^(?:" + config.ExcludeContainerRegex + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid container exclude regex: %w", err)
+		}
 	}
 
-	k8sClient, err := kubernetes.NewForConfig(k8sConfig)
-	if err != nil {
+	analyzer := &MetricsAnalyzer{
+		promClient:       promClient,
+		k8sClient:        k8sClient,
+		k8sConfig:        k8sConfig,
+		config:           config,
+		contextName:      contextName,
+		excludeContainer: excludeContainer,
+		alertDedup:       newAlertDeduplicator(config.AlertDedupInterval),
+	}
+	if err := analyzer.startInformers(); err != nil {
 		return nil, err
 	}
 
-	return &MetricsAnalyzer{
-		promClient: v1.NewAPI(promClient),
-		k8sClient:  k8sClient,
-		config:     config,
-	}, nil
+	return analyzer, nil
 }
 
-func (ma *MetricsAnalyzer) getMetricsForPod(podName string, namespace string) (PodMetrics, error) {
-	pod, err := ma.k8sClient.CoreV1().Pods(namespace).Get(context.Background(), podName, metav1.GetOptions{})
+func (ma *MetricsAnalyzer) getMetricsForPod(ctx context.Context, podName string, namespace string, debug bool) (PodMetrics, error) {
+	pod, err := ma.getPod(namespace, podName)
 	if err != nil {
 		return PodMetrics{}, err
 	}
 
-	var currentCPU, currentMemory float64
-	if len(pod.Spec.Containers) > 0 {
-		if cpu := pod.Spec.Containers[0].Resources.Limits.Cpu(); cpu != nil {
-			currentCPU = float64(cpu.MilliValue()) / 1000.0
+	currentCPU, currentMemory, excluded := ma.podSpecResources(pod.Spec)
+	var reasons []string
+	for _, container := range pod.Spec.Containers {
+		if !ma.isContainerExcluded(container.Name) {
+			reasons = append(reasons, ma.memoryConfigIssues(container)...)
 		}
-		if memory := pod.Spec.Containers[0].Resources.Limits.Memory(); memory != nil {
-			currentMemory = float64(memory.Value())
+	}
+	for _, missing := range ma.missingResources(pod) {
+		reasons = append(reasons, missing.Reason)
+	}
+
+	qlog := &queryLog{debug: debug}
+	maxCPU, cpuWindow, err := ma.getUsage(ctx, ma.cpuUsageExpr(podName, namespace), qlog)
+	if err != nil {
+		return PodMetrics{}, err
+	}
+
+	maxMemory, memoryWindow, err := ma.getUsage(ctx, ma.memoryUsageExpr(podName, namespace), qlog)
+	if err != nil {
+		return PodMetrics{}, err
+	}
+
+	nodePressure, err := ma.getNodePressure(ctx, pod.Spec.NodeName, qlog)
+	if err != nil {
+		return PodMetrics{}, err
+	}
+
+	ownerKind, _ := ma.resolveOwner(pod)
+	rec := ma.recommend(currentCPU, currentMemory, maxCPU, maxMemory, ma.workloadMemoryHeadroom(ownerKind, nodePressure.Level))
+	floors, err := ma.resourceFloors(pod)
+	if err != nil {
+		return PodMetrics{}, err
+	}
+
+	// Растущее потребление памяти похоже на утечку: пиковое значение за окно его недооценивает,
+	// поэтому память такому поду не уменьшаем
+	var memorySlope float64
+	if ma.config.MemoryGrowthThreshold > 0 {
+		memorySlope, err = ma.getMemorySlope(ctx, ma.memoryUsageExpr(podName, namespace), qlog)
+		if err != nil {
+			return PodMetrics{}, err
 		}
 	}
+	var trend string
+	if ma.isMemoryGrowing(memorySlope, maxMemory) {
+		trend = TrendGrowing
+		reasons = append(reasons, ReasonMemoryGrowing)
+		floors.Memory = math.Max(floors.Memory, currentMemory)
+	}
+	rec = ma.applyFloors(rec, floors, currentCPU, currentMemory)
+
+	bounds, err := ma.podLimitRangeBounds(pod)
+	if err != nil {
+		return PodMetrics{}, err
+	}
+	rec, clampReason := ma.clampToLimitRange(rec, bounds, currentCPU, currentMemory)
+	if clampReason != "" {
+		reasons = append(reasons, clampReason)
+	}
+	rec = ma.keepDisabledResources(rec, currentCPU, currentMemory)
 
-	cpuQuery := `max(rate(container_cpu_usage_seconds_total{pod="` + podName + `",namespace="` + namespace + `"}[5m]) * 100)`
-	cpuResult, _, err := ma.promClient.Query(context.Background(), cpuQuery, time.Now())
+	historyWindow := ma.clampWindow(ma.config.HistoryWindow, qlog)
+	cpuUsage, err := ma.querySeries(ctx, ma.cpuUsageExpr(podName, namespace), historyWindow, qlog)
 	if err != nil {
 		return PodMetrics{}, err
 	}
+	confidence := ma.getConfidence(pod, cpuUsage, historyWindow)
+	currentCPURequest, _ := ma.podSpecRequests(pod.Spec)
+	cpuRequest, cpuLimit := ma.recommendCPURequestAndLimit(cpuUsage, currentCPURequest, currentCPU, rec.CPU)
+	if bounds.Max.CPU > 0 {
+		cpuLimit = math.Min(cpuLimit, bounds.Max.CPU)
+	}
+	cpuLimit = math.Max(cpuLimit, math.Max(floors.CPU, bounds.Min.CPU))
+	cpuRequest = math.Min(math.Max(cpuRequest, math.Max(floors.CPU, bounds.Min.CPU)), cpuLimit)
+	if !ma.recommendsResource(corev1.ResourceCPU) {
+		cpuRequest, cpuLimit = currentCPURequest, currentCPU
+	}
 
-	memQuery := `max(container_memory_usage_bytes{pod="` + podName + `",namespace="` + namespace + `"})`
-	memResult, _, err := ma.promClient.Query(context.Background(), memQuery, time.Now())
+	egress, err := ma.queryValue(ctx, ma.networkEgressExpr(podName, namespace, qlog), qlog)
 	if err != nil {
 		return PodMetrics{}, err
 	}
 
-	var maxCPU, maxMemory float64
-	if cpuResult.Type() == model.ValVector {
-		vector := cpuResult.(model.Vector)
-		if len(vector) > 0 {
-			maxCPU = float64(vector[0].Value)
+	// Поду без запросов и лимитов нужно их задать, а не уменьшать: рекомендация по наблюдаемому
+	// использованию остается, но score не должен выдавать такой под за "максимально избыточный"
+	unbounded := ma.isUnbounded(pod.Spec)
+	if unbounded {
+		reasons = append(reasons, ReasonNoLimits)
+		rec.Score = 0
+	}
+
+	metrics := PodMetrics{
+		PodName:            podName,
+		Namespace:          namespace,
+		CurrentCPU:         currentCPU,
+		CurrentMemory:      currentMemory,
+		MaxCPU:             maxCPU,
+		MaxMemory:          maxMemory,
+		RecommendCPU:       rec.CPU,
+		RecommendMem:       rec.Memory,
+		RawRecommendCPU:    rec.RawCPU,
+		RawRecommendMem:    rec.RawMemory,
+		CPUWindow:          model.Duration(cpuWindow).String(),
+		MemoryWindow:       model.Duration(memoryWindow).String(),
+		OptimizationScore:  rec.Score,
+		CPUScore:           rec.CPUScore,
+		MemoryScore:        rec.MemoryScore,
+		ExcludedContainers: excluded,
+		Reasons:            reasons,
+		Unbounded:          unbounded,
+		Labels:             ma.allowedLabels(pod.Labels),
+		Images:             ma.containerImages(pod.Spec),
+		Confidence:         confidence,
+
+		Trend:               trend,
+		MemorySlope:         memorySlope,
+		NodeName:            pod.Spec.NodeName,
+		NodeMemoryPressure:  nodePressure.MemoryPercent,
+		NodePressure:        nodePressure.Level,
+		RecommendCPURequest: cpuRequest,
+		RecommendCPULimit:   cpuLimit,
+		NetworkEgressBytes:  egress,
+		NetworkEgressCost:   ma.egressCost(egress),
+		Warnings:            qlog.warnings,
+		Currency:            ma.config.Currency,
+		Queries:             qlog.queries,
+		LimitRangeClamped:   clampReason != "",
+
+		CPUChangePercent:    changePercent(currentCPU, rec.CPU),
+		MemoryChangePercent: changePercent(currentMemory, rec.Memory),
+	}
+	metrics.PotentialSavings = ma.podSavings(metrics)
+	metrics.EffectiveSavings = ma.effectiveSavings(metrics.PotentialSavings)
+	metrics.Priority = ma.priority(metrics.OptimizationScore, metrics.PotentialSavings)
+	return metrics, nil
+}
+
+// changePercent возвращает изменение recommended относительно current в процентах, округленное
+// до целого. Без текущего значения процент не определен, и функция возвращает 0.
+func changePercent(current, recommended float64) float64 {
+	if current <= 0 {
+		return 0
+	}
+	return math.Round((recommended - current) / current * 100)
+}
+
+// priority определяет приоритет оптимизации по score и абсолютной экономии в рублях
+func (ma *MetricsAnalyzer) priority(score, savings float64) string {
+	switch {
+	case score < ma.config.PriorityMinScore || savings <= 0:
+		return PriorityOK
+	case savings >= ma.config.PriorityCriticalSavings:
+		return PriorityCritical
+	case savings >= ma.config.PriorityHighSavings:
+		return PriorityHigh
+	case savings >= ma.config.PriorityMediumSavings:
+		return PriorityMedium
+	default:
+		return PriorityLow
+	}
+}
+
+// getConfidence оценивает (0..1) по ряду использования CPU за historyWindow, насколько можно
+// доверять рекомендации. Ряд уже запрошен для рекомендации запроса CPU, поэтому оценка
+// не требует отдельных запросов к Prometheus:
+//   - покрытие - доля точек ряда за HistoryWindow относительно ожидаемого количества;
+//   - возраст - доля HistoryWindow, которую под уже проработал;
+//   - стабильность - 1/(1+cv), где cv - коэффициент вариации использования CPU.
+//
+// Итог = min(покрытие, возраст) * (0.5 + 0.5*стабильность): мало данных обнуляет доверие,
+// а сильный разброс нагрузки снижает его не более чем вдвое.
+func (ma *MetricsAnalyzer) getConfidence(pod *corev1.Pod, usage []model.SamplePair, historyWindow time.Duration) float64 {
+	points := float64(len(usage))
+	var avg, stddev float64
+	if points > 0 {
+		for _, sample := range usage {
+			avg += float64(sample.Value)
+		}
+		avg /= points
+		for _, sample := range usage {
+			stddev += math.Pow(float64(sample.Value)-avg, 2)
+		}
+		stddev = math.Sqrt(stddev / points)
+	}
+
+	var coverage float64
+	if ma.config.QueryStep > 0 {
+		expected := float64(historyWindow / ma.config.QueryStep)
+		coverage = math.Min(1, points/expected)
+	}
+
+	started := pod.CreationTimestamp.Time
+	if pod.Status.StartTime != nil {
+		started = pod.Status.StartTime.Time
+	}
+	age := math.Min(1, time.Since(started).Hours()/historyWindow.Hours())
+
+	stability := 1.0
+	if avg > 0 {
+		stability = 1 / (1 + stddev/avg)
+	}
+
+	return math.Min(coverage, age) * (0.5 + 0.5*stability)
+}
+
+func (ma *MetricsAnalyzer) allowedLabels(podLabels map[string]string) map[string]string {
+	var result map[string]string
+	for _, key := range ma.config.LabelAllowlist {
+		if value, ok := podLabels[key]; ok {
+			if result == nil {
+				result = make(map[string]string)
+			}
+			result[key] = value
+		}
+	}
+	return result
+}
+
+// containerImages возвращает уникальные образы анализируемых контейнеров пода
+func (ma *MetricsAnalyzer) containerImages(spec corev1.PodSpec) []string {
+	var images []string
+	seen := make(map[string]bool)
+	for _, container := range spec.Containers {
+		if ma.isContainerExcluded(container.Name) || seen[container.Image] {
+			continue
+		}
+		seen[container.Image] = true
+		images = append(images, container.Image)
+	}
+	return images
+}
+
+// isUnbounded сообщает, что ни у одного анализируемого контейнера пода не заданы ни запросы, ни лимиты CPU и памяти
+func (ma *MetricsAnalyzer) isUnbounded(spec corev1.PodSpec) bool {
+	analyzed := 0
+	containers := append(append([]corev1.Container(nil), spec.Containers...), spec.InitContainers...)
+	for _, container := range containers {
+		if ma.isContainerExcluded(container.Name) {
+			continue
+		}
+		analyzed++
+		resources := container.Resources
+		if !resources.Limits.Cpu().IsZero() || !resources.Limits.Memory().IsZero() ||
+			!resources.Requests.Cpu().IsZero() || !resources.Requests.Memory().IsZero() {
+			return false
+		}
+	}
+	return analyzed > 0
+}
+
+// findUnboundedPods возвращает метрики подов без запросов и лимитов с рекомендуемыми по использованию значениями.
+// Пустой namespace означает все namespace кластера.
+func (ma *MetricsAnalyzer) findUnboundedPods(namespace string) ([]PodMetrics, error) {
+	pods, err := ma.listPods(namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	var refs []PodRef
+	for _, pod := range pods {
+		if ma.isUnbounded(pod.Spec) {
+			refs = append(refs, PodRef{Namespace: pod.Namespace, PodName: pod.Name})
 		}
 	}
 
-	if memResult.Type() == model.ValVector {
-		vector := memResult.(model.Vector)
-		if len(vector) > 0 {
-			maxMemory = float64(vector[0].Value)
+	result := []PodMetrics{}
+	for _, podResult := range ma.getMetricsForPods(context.Background(), refs) {
+		if podResult.Metrics != nil {
+			result = append(result, *podResult.Metrics)
 		}
 	}
+	return result, nil
+}
+
+type recommendation struct {
+	CPU       float64 // Ядра, округлено вверх
+	Memory    float64 // Байты, округлено вверх
+	RawCPU    float64
+	RawMemory float64
+	Score     float64 // Чем выше, тем больше необходимость оптимизации
+
+	// Составляющие score: относительное превышение текущих ресурсов над рекомендуемыми
+	CPUScore    float64
+	MemoryScore float64
+}
 
+// recommend рассчитывает рекомендацию по текущим ресурсам (ядра, байты) и пиковому использованию
+// (CPU в процентах ядра, память в байтах). memoryHeadroom - множитель запаса к пиковой памяти,
+// запас памяти при этом не меньше MemoryAbsoluteBuffer.
+func (ma *MetricsAnalyzer) recommend(currentCPU, currentMemory, maxCPU, maxMemory, memoryHeadroom float64) recommendation {
 	// Рекомендации с учетом текущих лимитов
-	recommendCPU := maxCPU / 100.0 // Конвертируем проценты в ядра
-	recommendMem := maxMemory * 1.2
+	rawCPU := maxCPU / 100.0 // Конвертируем проценты в ядра
+	rawMem := maxMemory * memoryHeadroom
+	if maxMemory > 0 {
+		rawMem = math.Max(rawMem, maxMemory+ma.config.MemoryAbsoluteBuffer*1024*1024)
+	}
+
+	// Округляем вверх до удобных значений, сырые значения сохраняем в ответе
+	recommendCPU := ma.clampToDirection(roundUp(rawCPU, float64(ma.config.CPURoundingMillicores)/1000.0), currentCPU)
+	recommendMem := ma.clampToDirection(roundUp(rawMem, ma.config.MemoryRoundingMB*1024*1024), currentMemory)
+	rawCPU = ma.clampToDirection(rawCPU, currentCPU)
+	rawMem = ma.clampToDirection(rawMem, currentMemory)
+
+	rec := recommendation{
+		CPU:       recommendCPU,
+		Memory:    recommendMem,
+		RawCPU:    rawCPU,
+		RawMemory: rawMem,
+	}
+	ma.scoreRecommendation(&rec, currentCPU, currentMemory)
+	return rec
+}
+
+// scoreRecommendation заполняет score рекомендации по текущим ресурсам
+func (ma *MetricsAnalyzer) scoreRecommendation(rec *recommendation, currentCPU, currentMemory float64) {
+	recommendCPU, recommendMem := rec.CPU, rec.Memory
 
 	// Вычисляем score для сортировки (чем больше разница между текущими и рекомендуемыми ресурсами, тем выше score)
 	var cpuDiff, memDiff float64
@@ -147,176 +817,1317 @@ func (ma *MetricsAnalyzer) getMetricsForPod(podName string, namespace string) (P
 		memDiff = 0.0 // Если оба значения = 0, считаем что разницы нет
 	}
 
-	optimizationScore := (cpuDiff + memDiff) / 2
+	rec.CPUScore = cpuDiff
+	rec.MemoryScore = memDiff
+	rec.Score = ma.weightedScore(cpuDiff, memDiff, ma.config.CPUScoreWeight, ma.config.MemoryScoreWeight)
+}
 
-	return PodMetrics{
-		PodName:           podName,
-		Namespace:         namespace,
-		CurrentCPU:        currentCPU,
-		CurrentMemory:     currentMemory,
-		MaxCPU:            maxCPU,
-		MaxMemory:         maxMemory,
-		RecommendCPU:      recommendCPU,
-		RecommendMem:      recommendMem,
-		OptimizationScore: optimizationScore,
-	}, nil
+// applyFloors поднимает рекомендацию до нижних границ, заданных аннотациями, и пересчитывает score
+func (ma *MetricsAnalyzer) applyFloors(rec recommendation, floors ResourceSize, currentCPU, currentMemory float64) recommendation {
+	if floors.CPU <= 0 && floors.Memory <= 0 {
+		return rec
+	}
+	rec.CPU = math.Max(rec.CPU, floors.CPU)
+	rec.RawCPU = math.Max(rec.RawCPU, floors.CPU)
+	rec.Memory = math.Max(rec.Memory, floors.Memory)
+	rec.RawMemory = math.Max(rec.RawMemory, floors.Memory)
+	ma.scoreRecommendation(&rec, currentCPU, currentMemory)
+	return rec
 }
 
-func (ma *MetricsAnalyzer) getClusterStats() (ClusterStats, error) {
-	log.Printf("Getting cluster stats...")
-	namespaces, err := ma.k8sClient.CoreV1().Namespaces().List(context.Background(), metav1.ListOptions{})
-	if err != nil {
-		log.Printf("Error getting namespaces: %v", err)
-		return ClusterStats{}, err
+// recommendsResource сообщает, строятся ли рекомендации по ресурсу согласно RecommendResources
+func (ma *MetricsAnalyzer) recommendsResource(name corev1.ResourceName) bool {
+	if len(ma.config.RecommendResources) == 0 {
+		return true
+	}
+	for _, resource := range ma.config.RecommendResources {
+		if resource == string(name) {
+			return true
+		}
 	}
-	log.Printf("Found %d namespaces", len(namespaces.Items))
+	return false
+}
 
-	var stats ClusterStats
-	var allPods []PodMetrics
+// keepDisabledResources оставляет текущие значения ресурсов, не входящих в RecommendResources,
+// и пересчитывает score, чтобы они не влияли на приоритет и экономию
+func (ma *MetricsAnalyzer) keepDisabledResources(rec recommendation, currentCPU, currentMemory float64) recommendation {
+	cpuEnabled, memoryEnabled := ma.recommendsResource(corev1.ResourceCPU), ma.recommendsResource(corev1.ResourceMemory)
+	if cpuEnabled && memoryEnabled {
+		return rec
+	}
+	if !cpuEnabled {
+		rec.CPU, rec.RawCPU = currentCPU, currentCPU
+	}
+	if !memoryEnabled {
+		rec.Memory, rec.RawMemory = currentMemory, currentMemory
+	}
+	ma.scoreRecommendation(&rec, currentCPU, currentMemory)
+	return rec
+}
 
-	for _, ns := range namespaces.Items {
-		log.Printf("Processing namespace: %s", ns.Name)
-		pods, err := ma.k8sClient.CoreV1().Pods(ns.Name).List(context.Background(), metav1.ListOptions{})
-		if err != nil {
-			log.Printf("Error getting pods in namespace %s: %v", ns.Name, err)
+// podSpecResources возвращает ресурсы, которые резервирует под с такой спецификацией,
+// и имена исключенных из анализа контейнеров.
+// Ресурс контейнера - его лимит, а если лимит не задан, то запрос (см. containerResources).
+// Init-контейнеры выполняются последовательно до старта основных, поэтому под резервирует
+// максимум из суммы основных контейнеров и самого крупного init-контейнера.
+// Sidecar-контейнеры (init с restartPolicy: Always) работают вместе с основными и суммируются с ними.
+func (ma *MetricsAnalyzer) podSpecResources(spec corev1.PodSpec) (float64, float64, []string) {
+	return ma.podSpecTotals(spec, containerResources)
+}
+
+// podSpecRequests возвращает запросы пода с такой спецификацией: CPU в ядрах, память в байтах.
+// Init- и sidecar-контейнеры учитываются так же, как в podSpecResources.
+func (ma *MetricsAnalyzer) podSpecRequests(spec corev1.PodSpec) (float64, float64) {
+	cpu, memory, _ := ma.podSpecTotals(spec, func(container corev1.Container) (float64, float64) {
+		return container.Resources.Requests.Cpu().AsApproximateFloat64(), container.Resources.Requests.Memory().AsApproximateFloat64()
+	})
+	return cpu, memory
+}
+
+// podSpecTotals сводит ресурсы контейнеров пода, взятые через containerValue, в ресурсы пода
+func (ma *MetricsAnalyzer) podSpecTotals(spec corev1.PodSpec, containerValue func(corev1.Container) (float64, float64)) (float64, float64, []string) {
+	var cpuTotal, memoryTotal float64
+	var excluded []string
+	for _, container := range spec.Containers {
+		if ma.isContainerExcluded(container.Name) {
+			excluded = append(excluded, container.Name)
 			continue
 		}
-		log.Printf("Found %d pods in namespace %s", len(pods.Items), ns.Name)
+		cpu, memory := containerValue(container)
+		cpuTotal += cpu
+		memoryTotal += memory
+	}
 
-		for _, pod := range pods.Items {
-			log.Printf("Getting metrics for pod %s in namespace %s", pod.Name, ns.Name)
-			metrics, err := ma.getMetricsForPod(pod.Name, ns.Name)
-			if err != nil {
-				log.Printf("Error getting metrics for pod %s: %v", pod.Name, err)
-				continue
-			}
+	var initCPU, initMemory float64
+	for _, container := range spec.InitContainers {
+		if ma.isContainerExcluded(container.Name) {
+			excluded = append(excluded, container.Name)
+			continue
+		}
+		cpu, memory := containerValue(container)
+		if container.RestartPolicy != nil && *container.RestartPolicy == corev1.ContainerRestartPolicyAlways {
+			cpuTotal += cpu
+			memoryTotal += memory
+			continue
+		}
+		initCPU = math.Max(initCPU, cpu)
+		initMemory = math.Max(initMemory, memory)
+	}
+
+	return math.Max(cpuTotal, initCPU), math.Max(memoryTotal, initMemory), excluded
+}
 
-			stats.TotalCurrentCPU += metrics.CurrentCPU
-			stats.TotalCurrentMemory += metrics.CurrentMemory
-			stats.TotalMaxCPU += metrics.MaxCPU
-			stats.TotalMaxMemory += metrics.MaxMemory
-			stats.TotalRecommendCPU += metrics.RecommendCPU
-			stats.TotalRecommendMem += metrics.RecommendMem
+// containerResources возвращает ресурсы, которые стоит учитывать в стоимости контейнера:
+// CPU в ядрах, память в байтах. Для каждого ресурса берется лимит, а если он не задан - запрос,
+// иначе burstable-контейнер только с запросами выглядел бы бесплатным. Без лимита и запроса - 0.
+func containerResources(container corev1.Container) (float64, float64) {
+	resources := container.Resources
+	cpu := resources.Limits.Cpu()
+	if cpu.IsZero() {
+		cpu = resources.Requests.Cpu()
+	}
+	memory := resources.Limits.Memory()
+	if memory.IsZero() {
+		memory = resources.Requests.Memory()
+	}
+	return float64(cpu.MilliValue()) / 1000.0, float64(memory.Value())
+}
+
+// memoryConfigIssues проверяет соотношение лимита и запроса памяти контейнера
+func (ma *MetricsAnalyzer) memoryConfigIssues(container corev1.Container) []string {
+	limit := container.Resources.Limits.Memory().Value()
+	request := container.Resources.Requests.Memory().Value()
 
-			allPods = append(allPods, metrics)
+	var issues []string
+	if limit == 0 {
+		issues = append(issues, fmt.Sprintf("container %s: no memory limit", container.Name))
+	}
+	if request == 0 {
+		issues = append(issues, fmt.Sprintf("container %s: no memory request", container.Name))
+	}
+	if limit > 0 && request > 0 && ma.config.MaxMemoryLimitRequestRatio > 0 {
+		if ratio := float64(limit) / float64(request); ratio > ma.config.MaxMemoryLimitRequestRatio {
+			issues = append(issues, fmt.Sprintf("container %s: memory limit/request ratio %.1f exceeds %.1f",
+				container.Name, ratio, ma.config.MaxMemoryLimitRequestRatio))
 		}
 	}
+	return issues
+}
 
-	// Сортируем поды по score (по убыванию)
-	sort.Slice(allPods, func(i, j int) bool {
-		return allPods[i].OptimizationScore > allPods[j].OptimizationScore
-	})
+// findMisconfigurations возвращает поды с проблемами конфигурации памяти.
+// Пустой namespace означает все namespace кластера.
+func (ma *MetricsAnalyzer) findMisconfigurations(namespace string) ([]Misconfiguration, error) {
+	pods, err := ma.listPods(namespace)
+	if err != nil {
+		return nil, err
+	}
 
-	stats.TotalPods = len(allPods)
-	stats.Pods = allPods
+	result := []Misconfiguration{}
+	for _, pod := range pods {
+		var reasons []string
+		for _, container := range pod.Spec.Containers {
+			if ma.isContainerExcluded(container.Name) {
+				continue
+			}
+			reasons = append(reasons, ma.memoryConfigIssues(container)...)
+		}
+		if len(reasons) > 0 {
+			result = append(result, Misconfiguration{
+				PodName:   pod.Name,
+				Namespace: pod.Namespace,
+				Reasons:   reasons,
+			})
+		}
+	}
+	return result, nil
+}
 
-	// Рассчитываем потенциальную экономию
-	cpuDelta := stats.TotalCurrentCPU - stats.TotalRecommendCPU
-	memDeltaMB := (stats.TotalCurrentMemory - stats.TotalRecommendMem) / (1024 * 1024)
-	stats.PotentialSavings = (cpuDelta * ma.config.CPUCostPerCore) + (memDeltaMB * ma.config.MemoryCostPerMB)
+// roundUp округляет значение вверх до ближайшего кратного step. step <= 0 отключает округление.
+func roundUp(value, step float64) float64 {
+	if step <= 0 {
+		return value
+	}
+	return math.Ceil(value/step) * step
+}
 
-	log.Printf("Cluster stats calculated: %d pods, potential savings: %.2f rub", stats.TotalPods, stats.PotentialSavings)
-	return stats, nil
+// clampToDirection ограничивает рекомендацию согласно RecommendationDirection.
+// Незаданное текущее значение (0) не ограничивает рекомендацию.
+func (ma *MetricsAnalyzer) clampToDirection(recommend, current float64) float64 {
+	if current <= 0 {
+		return recommend
+	}
+	switch ma.config.RecommendationDirection {
+	case RecommendationDirectionIncrease:
+		return math.Max(recommend, current)
+	case RecommendationDirectionDecrease:
+		return math.Min(recommend, current)
+	default:
+		return recommend
+	}
 }
 
-func (ma *MetricsAnalyzer) formatRecommendation(metrics PodMetrics) string {
-	currentMemMB := metrics.CurrentMemory / (1024 * 1024)
-	maxMemMB := metrics.MaxMemory / (1024 * 1024)
-	recommendMemMB := metrics.RecommendMem / (1024 * 1024)
+// Агрегируем по контейнерам пода (PodAggregation), отбрасывая агрегированные по поду ряды
+// (container="") и pause-контейнер, чтобы исключенные сайдкары не попадали в итог
+func (ma *MetricsAnalyzer) cpuUsageExpr(podName, namespace string) string {
+	return ma.podAggregation() + `(rate(` + ma.config.CPUUsageMetric + `{` + ma.containerSelector(podName, namespace) + `}[5m]) * 100)`
+}
 
-	cpuDelta := metrics.RecommendCPU - metrics.CurrentCPU
-	memDeltaMB := recommendMemMB - currentMemMB
-	costDelta := (cpuDelta * ma.config.CPUCostPerCore) + (memDeltaMB * ma.config.MemoryCostPerMB)
+func (ma *MetricsAnalyzer) memoryUsageExpr(podName, namespace string) string {
+	return ma.podAggregation() + `(` + ma.config.MemoryUsageMetric + `{` + ma.containerSelector(podName, namespace) + `})`
+}
 
-	var result string
-	result += fmt.Sprintf("Анализ пода: %s\n", metrics.PodName)
-	result += fmt.Sprintf("Namespace: %s\n\n", metrics.Namespace)
+// podAggregation возвращает агрегирующую функцию PromQL для рядов пода, по умолчанию sum
+func (ma *MetricsAnalyzer) podAggregation() string {
+	if ma.config.PodAggregation == PodAggregationMax {
+		return PodAggregationMax
+	}
+	return PodAggregationSum
+}
 
-	result += "Текущие ресурсы:\n"
-	result += fmt.Sprintf("CPU: %.2f ядер\n", metrics.CurrentCPU)
-	result += fmt.Sprintf("Память: %.2f МБ\n\n", currentMemMB)
+// Сетевые счетчики cAdvisor относятся к поду целиком и пишутся в ряд pause-контейнера,
+// поэтому фильтр containerSelector здесь не применяется
+func (ma *MetricsAnalyzer) networkEgressExpr(podName, namespace string, qlog *queryLog) string {
+	return `sum(rate(` + ma.config.NetworkTransmitMetric + `{pod="` + podName + `",namespace="` + namespace + `"}[` +
+		model.Duration(ma.clampWindow(ma.config.HistoryWindow, qlog)).String() + `]))`
+}
 
-	result += "Максимальное использование:\n"
+// egressCost пересчитывает средний исходящий трафик в байтах/с в стоимость за 30 дней
+func (ma *MetricsAnalyzer) egressCost(bytesPerSecond float64) float64 {
+	gbPerMonth := bytesPerSecond * (30 * 24 * time.Hour).Seconds() / (1024 * 1024 * 1024)
+	return gbPerMonth * ma.config.EgressCostPerGB
+}
+
+var percentiles = []struct {
+	name     string
+	quantile float64
+}{
+	{"p50", 0.5},
+	{"p90", 0.9},
+	{"p95", 0.95},
+	{"p99", 0.99},
+}
+
+// addPercentiles дополняет метрики пода перцентилями использования за окно HistoryWindow.
+// Требует по одному дополнительному запросу на каждый перцентиль, поэтому вызывается только по запросу клиента.
+func (ma *MetricsAnalyzer) addPercentiles(ctx context.Context, metrics *PodMetrics, debug bool) error {
+	qlog := &queryLog{debug: debug}
+	cpu, err := ma.getPercentiles(ctx, ma.cpuUsageExpr(metrics.PodName, metrics.Namespace), qlog)
+	if err != nil {
+		return err
+	}
+	memory, err := ma.getPercentiles(ctx, ma.memoryUsageExpr(metrics.PodName, metrics.Namespace), qlog)
+	if err != nil {
+		return err
+	}
+
+	metrics.CPUPercentiles = cpu
+	metrics.MemoryPercentiles = memory
+	metrics.Warnings = appendUnique(metrics.Warnings, qlog.warnings...)
+	metrics.Queries = append(metrics.Queries, qlog.queries...)
+	return nil
+}
+
+func (ma *MetricsAnalyzer) getPercentiles(ctx context.Context, expr string, qlog *queryLog) (map[string]float64, error) {
+	window := model.Duration(ma.clampWindow(ma.config.HistoryWindow, qlog)).String()
+	step := model.Duration(ma.config.QueryStep).String()
+
+	result := make(map[string]float64, len(percentiles))
+	for _, p := range percentiles {
+		value, err := ma.queryValue(ctx, fmt.Sprintf("quantile_over_time(%g, (%s)[%s:%s])", p.quantile, expr, window, step), qlog)
+		if err != nil {
+			return nil, err
+		}
+		result[p.name] = value
+	}
+	return result, nil
+}
+
+// getMemorySlope возвращает наклон линейной регрессии использования памяти за HistoryWindow в байтах/с
+func (ma *MetricsAnalyzer) getMemorySlope(ctx context.Context, expr string, qlog *queryLog) (float64, error) {
+	window := model.Duration(ma.clampWindow(ma.config.HistoryWindow, qlog)).String()
+	step := model.Duration(ma.config.QueryStep).String()
+	return ma.queryValue(ctx, fmt.Sprintf("deriv((%s)[%s:%s])", expr, window, step), qlog)
+}
+
+// isMemoryGrowing проверяет, что рост памяти по тренду за HistoryWindow превышает
+// MemoryGrowthThreshold от пикового использования
+func (ma *MetricsAnalyzer) isMemoryGrowing(slope, maxMemory float64) bool {
+	if ma.config.MemoryGrowthThreshold <= 0 || slope <= 0 || maxMemory <= 0 {
+		return false
+	}
+	return slope*ma.config.HistoryWindow.Seconds()/maxMemory >= ma.config.MemoryGrowthThreshold
+}
+
+// recommendCPURequestAndLimit рекомендует запрос и лимит CPU по ряду использования CPU за HistoryWindow
+// (в процентах ядра, как max_cpu), учитывая только рабочее время, если задано BusinessHours.
+// Запрос - 95-й перцентиль, лимит - 99-й перцентиль, но не меньше запроса, умноженного на
+// CPULimitRequestRatio, чтобы оставить место для всплесков. Оба значения ограничиваются
+// RecommendationDirection относительно текущих запроса и лимита. Без точек в ряду
+// рекомендуется fallback и для запроса, и для лимита.
+func (ma *MetricsAnalyzer) recommendCPURequestAndLimit(usage []model.SamplePair, currentRequest, currentLimit, fallback float64) (float64, float64) {
+	values := make([]float64, 0, len(usage))
+	for _, sample := range usage {
+		if bh := ma.config.BusinessHours; bh != nil && !bh.contains(sample.Timestamp.Time()) {
+			continue
+		}
+		values = append(values, float64(sample.Value)/100.0)
+	}
+	if len(values) == 0 {
+		return fallback, fallback
+	}
+	sort.Float64s(values)
+
+	step := float64(ma.config.CPURoundingMillicores) / 1000.0
+	request := ma.clampToDirection(roundUp(quantile(values, 0.95), step), currentRequest)
+	limit := math.Max(quantile(values, 0.99), request*ma.config.CPULimitRequestRatio)
+	limit = ma.clampToDirection(roundUp(limit, step), currentLimit)
+	return request, math.Max(limit, request)
+}
+
+// quantile возвращает квантиль q отсортированных значений с линейной интерполяцией, как quantile_over_time
+func quantile(sorted []float64, q float64) float64 {
+	rank := q * float64(len(sorted)-1)
+	lower := int(math.Floor(rank))
+	upper := min(lower+1, len(sorted)-1)
+	weight := rank - float64(lower)
+	return sorted[lower]*(1-weight) + sorted[upper]*weight
+}
+
+func (ma *MetricsAnalyzer) isContainerExcluded(name string) bool {
+	return ma.excludeContainer != nil && ma.excludeContainer.MatchString(name)
+}
+
+// containerSelector возвращает набор label-матчеров PromQL для контейнеров пода с учетом исключений
+func (ma *MetricsAnalyzer) containerSelector(podName, namespace string) string {
+	selector := `pod="` + podName + `",namespace="` + namespace + `",container!="",container!="POD"`
+	if ma.config.ExcludeContainerRegex != "" {
+		selector += `,container!~"` + ma.config.ExcludeContainerRegex + `"`
+	}
+	return selector
+}
+
+// getUsage возвращает наибольшее использование ресурса по всем окнам истории и окно, которое его дало
+func (ma *MetricsAnalyzer) getUsage(ctx context.Context, expr string, qlog *queryLog) (float64, time.Duration, error) {
+	expr = ma.businessHoursExpr(expr)
+	windows := ma.config.RecommendationWindows
+	if len(windows) == 0 {
+		windows = []time.Duration{ma.config.HistoryWindow}
+	}
+
+	var usage float64
+	var usageWindow time.Duration
+	for _, window := range windows {
+		window = ma.clampWindow(window, qlog)
+		value, err := ma.getWindowUsage(ctx, expr, window, qlog)
+		if err != nil {
+			return 0, 0, err
+		}
+		if usageWindow == 0 || value > usage {
+			usage = value
+			usageWindow = window
+		}
+	}
+	return usage, usageWindow, nil
+}
+
+// getWindowUsage возвращает использование ресурса за окно согласно RecommendationMode
+func (ma *MetricsAnalyzer) getWindowUsage(ctx context.Context, expr string, window time.Duration, qlog *queryLog) (float64, error) {
+	windowStr := model.Duration(window).String()
+	step := model.Duration(ma.config.QueryStep).String()
+
+	switch ma.config.RecommendationMode {
+	case RecommendationModeP95:
+		return ma.queryValue(ctx, fmt.Sprintf("quantile_over_time(0.95, (%s)[%s:%s])", expr, windowStr, step), qlog)
+	case RecommendationModeDecay:
+		return ma.queryDecayed(ctx, expr, window, qlog)
+	default:
+		return ma.queryValue(ctx, fmt.Sprintf("max_over_time((%s)[%s:%s])", expr, windowStr, step), qlog)
+	}
+}
+
+func (ma *MetricsAnalyzer) queryValue(ctx context.Context, query string, qlog *queryLog) (float64, error) {
+	result, queryWarns, err := ma.promClient.Query(ctx, query, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	qlog.add(query, result, queryWarns)
+
+	if result.Type() != model.ValVector {
+		return 0, nil
+	}
+
+	// Запросы агрегируются до одного ряда на под. Если рядов больше (например, при
+	// нестандартных метриках), это ряды отдельных контейнеров - суммируем их, а не берем первый.
+	vector := result.(model.Vector)
+	if len(vector) > 1 {
+		log.Printf("Warning: query returned %d series, expected 1, summing them: %s", len(vector), query)
+	}
+	var value float64
+	for _, sample := range vector {
+		value += float64(sample.Value)
+	}
+	return value, nil
+}
+
+func (ma *MetricsAnalyzer) queryDecayed(ctx context.Context, expr string, window time.Duration, qlog *queryLog) (float64, error) {
+	values, err := ma.querySeries(ctx, expr, window, qlog)
+	if err != nil {
+		return 0, err
+	}
+	return decayedMax(values, time.Now(), ma.config.DecayHalfLife), nil
+}
+
+// querySeries возвращает ряд выражения за последние window с шагом QueryStep.
+// Несколько рядов складываются поточечно, как в queryValue.
+func (ma *MetricsAnalyzer) querySeries(ctx context.Context, expr string, window time.Duration, qlog *queryLog) ([]model.SamplePair, error) {
+	end := time.Now()
+	result, queryWarns, err := ma.promClient.QueryRange(ctx, expr, v1.Range{
+		Start: end.Add(-window),
+		End:   end,
+		Step:  ma.config.QueryStep,
+	})
+	if err != nil {
+		return nil, err
+	}
+	qlog.add(expr, result, queryWarns)
+
+	if result.Type() != model.ValMatrix {
+		return nil, nil
+	}
+
+	matrix := result.(model.Matrix)
+	if len(matrix) > 1 {
+		log.Printf("Warning: range query returned %d series, expected 1, summing them: %s", len(matrix), expr)
+	}
+	return sumSeries(matrix), nil
+}
+
+// sumSeries складывает ряды матрицы поточечно по меткам времени
+func sumSeries(matrix model.Matrix) []model.SamplePair {
+	if len(matrix) == 1 {
+		return matrix[0].Values
+	}
+
+	sums := make(map[model.Time]model.SampleValue)
+	for _, stream := range matrix {
+		for _, sample := range stream.Values {
+			sums[sample.Timestamp] += sample.Value
+		}
+	}
+
+	values := make([]model.SamplePair, 0, len(sums))
+	for timestamp, value := range sums {
+		values = append(values, model.SamplePair{Timestamp: timestamp, Value: value})
+	}
+	sort.Slice(values, func(i, j int) bool {
+		return values[i].Timestamp < values[j].Timestamp
+	})
+	return values
+}
+
+// decayedMax возвращает максимум ряда, где каждая точка взвешена по возрасту
+// с заданным периодом полураспада
+func decayedMax(values []model.SamplePair, now time.Time, halfLife time.Duration) float64 {
+	var result float64
+	for _, sample := range values {
+		weight := 1.0
+		if halfLife > 0 {
+			age := now.Sub(sample.Timestamp.Time())
+			weight = math.Pow(2, -age.Hours()/halfLife.Hours())
+		}
+		if value := float64(sample.Value) * weight; value > result {
+			result = value
+		}
+	}
+	return result
+}
+
+func (ma *MetricsAnalyzer) getMetricsForPods(ctx context.Context, refs []PodRef) []BatchMetricsResult {
+	results := make([]BatchMetricsResult, len(refs))
+
+	workers := ma.config.BatchWorkers
+	if workers <= 0 {
+		workers = 1
+	}
+	sem := make(chan struct{}, workers)
+
+	var wg sync.WaitGroup
+	for i, ref := range refs {
+		wg.Add(1)
+		go func(i int, ref PodRef) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			result := BatchMetricsResult{Namespace: ref.Namespace, PodName: ref.PodName}
+			metrics, err := ma.getMetricsForPod(ctx, ref.PodName, ref.Namespace, false)
+			if err != nil {
+				log.Printf("Error getting metrics for pod %s in namespace %s: %v", ref.PodName, ref.Namespace, err)
+				result.Error = err.Error()
+			} else {
+				result.Metrics = &metrics
+			}
+			// Каждая горутина пишет только в свой индекс, порядок ответа совпадает с запросом
+			results[i] = result
+		}(i, ref)
+	}
+	wg.Wait()
+
+	return results
+}
+
+// ScanProgress вызывается после обработки каждого пода во время сканирования кластера
+type ScanProgress func(processed, total int)
+
+type ScanOptions struct {
+	Progress ScanProgress
+	// Если задан, метрики подов передаются сюда по мере расчета в порядке namespace,
+	// не накапливаются в ClusterStats.Pods и не сортируются
+	OnPod func(PodMetrics)
+	// Namespace для сканирования, пустая строка - все namespace
+	Namespace string
+	// Постраничное сканирование: не больше NamespaceLimit namespace за раз (0 - без ограничения),
+	// начиная со следующего после Cursor по алфавиту. Не используются вместе с Namespace.
+	NamespaceLimit int
+	Cursor         string
+	// Анализировать только поды с аннотацией AnnotationKey=AnnotationValue. API Kubernetes
+	// не умеет отбирать по аннотациям, поэтому фильтр применяется после получения списка подов.
+	AnnotationKey   string
+	AnnotationValue string
+	// Анализировать только поды, метки которых подходят под селектор, nil - все поды
+	LabelSelector labels.Selector
+	// Сводить поды одной рабочей нагрузки с несколькими репликами в одну рекомендацию
+	// в ClusterStats.Workloads. Не действует вместе с OnPod.
+	CollapseReplicas bool
+	// Минимальный размер анализируемого пода, nil - MinAnalyzableCPU/MinAnalyzableMemory из конфигурации
+	MinSize *ResourceSize
+	// Включать в метрики подов выполненные запросы PromQL с их результатами
+	Debug bool
+	// Анализировать и завершившиеся с ошибкой (Failed), в том числе вытесненные (Evicted), поды
+	IncludeTerminated bool
+}
+
+// isDefault проверяет, что сканирование охватывает весь кластер без фильтров и его результат можно кешировать
+func (opts ScanOptions) isDefault() bool {
+	return opts.Namespace == "" && opts.NamespaceLimit == 0 && opts.Cursor == "" &&
+		opts.MinSize == nil && opts.OnPod == nil && !opts.CollapseReplicas && opts.AnnotationKey == "" &&
+		!opts.Debug && !opts.IncludeTerminated && opts.LabelSelector == nil
+}
+
+// withoutStreaming возвращает те же параметры сканирования без потоковой передачи подов
+func (opts ScanOptions) withoutStreaming() ScanOptions {
+	opts.OnPod = nil
+	return opts
+}
+
+// ResourceSize - ресурсы пода: CPU в ядрах, память в байтах
+type ResourceSize struct {
+	CPU    float64
+	Memory float64
+}
+
+// parseMinSize разбирает значение параметра ?min_size= вида "<cpu>,<память>" в единицах Kubernetes,
+// например "100m,128Mi". Нулевое значение отключает порог по своему ресурсу, "0,0" - оба.
+func parseMinSize(value string) (*ResourceSize, error) {
+	parts := strings.Split(value, ",")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("min_size must be <cpu>,<memory>, got %q", value)
+	}
+	cpu, err := resource.ParseQuantity(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return nil, fmt.Errorf("invalid min_size cpu: %w", err)
+	}
+	memory, err := resource.ParseQuantity(strings.TrimSpace(parts[1]))
+	if err != nil {
+		return nil, fmt.Errorf("invalid min_size memory: %w", err)
+	}
+	return &ResourceSize{CPU: cpu.AsApproximateFloat64(), Memory: memory.AsApproximateFloat64()}, nil
+}
+
+// scanOptionsFromRequest разбирает общие параметры сканирования ?namespace=, ?min_size=,
+// ?annotation=, ?namespaces_per_scan=, ?cursor=, ?collapse_replicas=, ?debug= и ?include_terminated=
+func scanOptionsFromRequest(r *http.Request) (ScanOptions, error) {
+	opts := ScanOptions{
+		Namespace: r.URL.Query().Get("namespace"),
+		Cursor:    r.URL.Query().Get("cursor"),
+
+		CollapseReplicas: r.URL.Query().Get("collapse_replicas") == "true",
+		Debug:            r.URL.Query().Get("debug") == "true",
+
+		IncludeTerminated: r.URL.Query().Get("include_terminated") == "true",
+	}
+	if value := r.URL.Query().Get("annotation"); value != "" {
+		key, annotationValue, ok := strings.Cut(value, "=")
+		if !ok || key == "" {
+			return ScanOptions{}, fmt.Errorf("annotation must be key=value, got %q", value)
+		}
+		opts.AnnotationKey, opts.AnnotationValue = key, annotationValue
+	}
+	if value := r.URL.Query().Get("namespaces_per_scan"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 0 {
+			return ScanOptions{}, fmt.Errorf("invalid namespaces_per_scan %q", value)
+		}
+		opts.NamespaceLimit = limit
+	}
+	if value := r.URL.Query().Get("min_size"); value != "" {
+		minSize, err := parseMinSize(value)
+		if err != nil {
+			return ScanOptions{}, err
+		}
+		opts.MinSize = minSize
+	}
+	return opts, nil
+}
+
+// isTerminated сообщает, что под завершился с ошибкой или был вытеснен с узла
+func isTerminated(pod *corev1.Pod) bool {
+	return pod.Status.Phase == corev1.PodFailed || pod.Status.Reason == "Evicted"
+}
+
+// isBelowMinSize проверяет, что запросы пода меньше каждого заданного порога. Нулевой порог
+// не ограничивает свой ресурс. Поды без запросов и лимитов не пропускаются: им нужно задать
+// ресурсы, а не искать экономию.
+func (ma *MetricsAnalyzer) isBelowMinSize(pod *corev1.Pod, minSize ResourceSize) bool {
+	if minSize.CPU <= 0 && minSize.Memory <= 0 {
+		return false
+	}
+	if ma.isUnbounded(pod.Spec) {
+		return false
+	}
+	cpu, memory := ma.podSpecRequests(pod.Spec)
+	return (minSize.CPU <= 0 || cpu < minSize.CPU) && (minSize.Memory <= 0 || memory < minSize.Memory)
+}
+
+func (ma *MetricsAnalyzer) getClusterStats(opts ScanOptions) (ClusterStats, error) {
+	if opts.isDefault() && ma.isFollower() {
+		// Результат ведущей реплики держим в своем кеше, чтобы не запрашивать его на каждый запрос
+		if stats, ok := ma.statsCache.load(ma.config.StatsCacheTTL); ok {
+			return stats, nil
+		}
+		stats, err := ma.fetchSharedClusterStats()
+		if err != nil {
+			return ClusterStats{}, err
+		}
+		ma.statsCache.store(stats)
+		return stats, nil
+	}
+
+	log.Printf("Getting cluster stats...")
+	stats := ClusterStats{Currency: ma.config.Currency}
+	namespaces := []string{opts.Namespace}
+	if opts.Namespace == "" {
+		list, err := ma.listNamespaces()
+		if err != nil {
+			log.Printf("Error getting namespaces: %v", err)
+			return ClusterStats{}, err
+		}
+		namespaces = namespaces[:0]
+		for _, ns := range list {
+			// Namespace отсортированы по имени, поэтому курсор - имя последнего обработанного
+			if opts.Cursor != "" && ns.Name <= opts.Cursor {
+				continue
+			}
+			namespaces = append(namespaces, ns.Name)
+		}
+		if opts.NamespaceLimit > 0 && len(namespaces) > opts.NamespaceLimit {
+			namespaces = namespaces[:opts.NamespaceLimit]
+			stats.NextCursor = namespaces[len(namespaces)-1]
+		}
+	}
+	log.Printf("Found %d namespaces", len(namespaces))
+
+	minSize := ResourceSize{CPU: ma.config.MinAnalyzableCPU, Memory: ma.config.MinAnalyzableMemory * 1024 * 1024}
+	if opts.MinSize != nil {
+		minSize = *opts.MinSize
+	}
+
+	// Сначала собираем список всех подов, чтобы знать общее количество для прогресса
+	var refs []PodRef
+	for _, ns := range namespaces {
+		log.Printf("Processing namespace: %s", ns)
+		pods, err := ma.listPods(ns)
+		if err != nil {
+			log.Printf("Error getting pods in namespace %s: %v", ns, err)
+			continue
+		}
+		log.Printf("Found %d pods in namespace %s", len(pods), ns)
+
+		for _, pod := range pods {
+			if opts.AnnotationKey != "" {
+				if value, ok := pod.Annotations[opts.AnnotationKey]; !ok || value != opts.AnnotationValue {
+					continue
+				}
+			}
+			if opts.LabelSelector != nil && !opts.LabelSelector.Matches(labels.Set(pod.Labels)) {
+				continue
+			}
+			// Кластер не всегда удаляет завершившиеся поды, а их метрики устарели или нулевые
+			if !opts.IncludeTerminated && isTerminated(pod) {
+				stats.ExcludedTerminatedPods++
+				continue
+			}
+			ref := PodRef{Namespace: ns, PodName: pod.Name}
+			if ma.skipsOptimization(pod) {
+				stats.SkippedPods = append(stats.SkippedPods, ref)
+				continue
+			}
+			if ma.isBelowMinSize(pod, minSize) {
+				stats.SkippedSmallPods++
+				continue
+			}
+			refs = append(refs, ref)
+		}
+	}
+
+	allPods := []PodMetrics{} // Пустой кластер отдает "pods": [], а не null
+	var alertPods []PodMetrics
+	scanErrors := newScanErrors()
+	var utilization clusterUtilization
+
+	// Бюджет ScanTimeout ограничивает и каждый запрос к Prometheus: один зависший запрос
+	// иначе держал бы сканирование сколько угодно
+	ctx := context.Background()
+	if ma.config.ScanTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, ma.config.ScanTimeout)
+		defer cancel()
+	}
+
+	for i, ref := range refs {
+		err := ctx.Err()
+		var metrics PodMetrics
+		if err == nil {
+			log.Printf("Getting metrics for pod %s in namespace %s (%d/%d)", ref.PodName, ref.Namespace, i+1, len(refs))
+			metrics, err = ma.getMetricsForPod(ctx, ref.PodName, ref.Namespace, opts.Debug)
+		}
+		// Под, прерванный по ScanTimeout, не проанализирован, а не завершился ошибкой
+		if err != nil && ctx.Err() != nil {
+			stats.Partial = true
+			stats.UnprocessedPods = len(refs) - i
+			log.Printf("Scan timeout %v exceeded, returning partial stats: %d pods not processed", ma.config.ScanTimeout, stats.UnprocessedPods)
+			break
+		}
+		if opts.Progress != nil {
+			opts.Progress(i+1, len(refs))
+		}
+		if err != nil {
+			// Одна и та же причина (например, недоступность Prometheus) валит много подов подряд,
+			// поэтому в лог пишется только первая ошибка каждой категории, остальные - итогом
+			if scanErrors.add(ref, err) {
+				log.Printf("Error getting metrics for pod %s: %v", ref.PodName, err)
+			}
+			continue
+		}
+
+		stats.TotalCurrentCPU += metrics.CurrentCPU
+		stats.TotalCurrentMemory += metrics.CurrentMemory
+		stats.TotalMaxCPU += metrics.MaxCPU
+		stats.TotalMaxMemory += metrics.MaxMemory
+		stats.TotalRecommendCPU += metrics.RecommendCPU
+		stats.TotalRecommendMem += metrics.RecommendMem
+		stats.TotalNetworkEgressCost += metrics.NetworkEgressCost
+		stats.Warnings = appendUnique(stats.Warnings, metrics.Warnings...)
+		stats.TotalPods++
+		if pod, err := ma.getPod(ref.Namespace, ref.PodName); err == nil {
+			utilization.add(ma, pod, metrics)
+		}
+
+		if opts.OnPod != nil {
+			opts.OnPod(metrics)
+			// Поды при потоковой передаче не накапливаются, поэтому для уведомлений
+			// запоминаются только поды, экономия которых превышает порог
+			if ma.config.AlertPodSavingsThreshold > 0 && ma.podSavings(metrics) >= ma.config.AlertPodSavingsThreshold {
+				alertPods = append(alertPods, metrics)
+			}
+			continue
+		}
+		allPods = append(allPods, metrics)
+	}
+
+	stats.FailedPods, stats.Errors = scanErrors.summary()
+	if stats.FailedPods > 0 {
+		log.Printf("Failed to get metrics for %d pods: %s", stats.FailedPods, scanErrors)
+	}
+
+	// Сортируем поды по score (по убыванию)
+	sort.Slice(allPods, func(i, j int) bool {
+		return allPods[i].OptimizationScore > allPods[j].OptimizationScore
+	})
+
+	stats.Pods = allPods
+	if opts.CollapseReplicas {
+		stats.Pods, stats.Workloads = ma.collapseReplicas(allPods)
+	}
+
+	// Рассчитываем потенциальную экономию
+	cpuDelta := stats.TotalCurrentCPU - stats.TotalRecommendCPU
+	memDeltaMB := (stats.TotalCurrentMemory - stats.TotalRecommendMem) / (1024 * 1024)
+	stats.CPUSavings = cpuDelta * ma.config.CPUCostPerCore
+	stats.MemorySavings = memDeltaMB * ma.config.MemoryCostPerMB
+	stats.PotentialSavings = stats.CPUSavings + stats.MemorySavings
+	stats.EffectiveSavings = ma.effectiveSavings(stats.PotentialSavings)
+
+	stats.CPUUtilizationPercent, stats.MemoryUtilizationPercent = utilization.percents()
+
+	log.Printf("Cluster stats calculated: %d pods, potential savings: %.2f %s", stats.TotalPods, stats.PotentialSavings, ma.config.Currency)
+	// Порог экономии по кластеру имеет смысл только для полного сканирования без фильтров:
+	// частичные итоги занижают экономию и занимают интервал дедупликации уведомления по кластеру
+	if opts.isDefault() && !stats.Partial {
+		go ma.notifySavings(stats)
+		ma.statsCache.store(stats)
+	} else if opts.OnPod != nil && opts.withoutStreaming().isDefault() && !stats.Partial {
+		// Потоковое полное сканирование уведомляет так же, но без подов в кеш не попадает
+		alertStats := stats
+		alertStats.Pods = alertPods
+		go ma.notifySavings(alertStats)
+	}
+	return stats, nil
+}
+
+// clusterUtilization накапливает пиковое использование и запросы подов с заданным запросом:
+// CPU в ядрах, память в байтах
+type clusterUtilization struct {
+	usedCPU, requestedCPU       float64
+	usedMemory, requestedMemory float64
+}
+
+func (u *clusterUtilization) add(ma *MetricsAnalyzer, pod *corev1.Pod, metrics PodMetrics) {
+	cpuRequest, memoryRequest := ma.podSpecRequests(pod.Spec)
+	if cpuRequest > 0 {
+		u.usedCPU += metrics.MaxCPU / 100 // MaxCPU в процентах ядра
+		u.requestedCPU += cpuRequest
+	}
+	if memoryRequest > 0 {
+		u.usedMemory += metrics.MaxMemory
+		u.requestedMemory += memoryRequest
+	}
+}
+
+// percents возвращает использование CPU и памяти в процентах от запросов
+func (u *clusterUtilization) percents() (float64, float64) {
+	var cpu, memory float64
+	if u.requestedCPU > 0 {
+		cpu = u.usedCPU / u.requestedCPU * 100
+	}
+	if u.requestedMemory > 0 {
+		memory = u.usedMemory / u.requestedMemory * 100
+	}
+	return cpu, memory
+}
+
+// effectiveSavings пересчитывает экономию на ресурсах подов в ожидаемую экономию на узлах
+func (ma *MetricsAnalyzer) effectiveSavings(savings float64) float64 {
+	if ma.config.ClusterOverheadFactor <= 1 {
+		return savings
+	}
+	return savings / ma.config.ClusterOverheadFactor
+}
+
+// currencyLabel возвращает обозначение валюты для текстовых отчетов
+func (ma *MetricsAnalyzer) currencyLabel() string {
+	if ma.config.Currency == "" || ma.config.Currency == "RUB" {
+		return "руб."
+	}
+	return ma.config.Currency
+}
+
+// getLegacyPodMetrics возвращает метрики подов namespace для /metrics в прежнем виде: все поды
+// в порядке имен, без пропуска по аннотации, статусу и MinAnalyzable*. Порог ?min_size=
+// применяется, только если задан явно. Метрики подов из последнего полного сканирования
+// (моложе StatsCacheTTL) берутся из кеша, Prometheus опрашивается только для остальных.
+func (ma *MetricsAnalyzer) getLegacyPodMetrics(ctx context.Context, opts ScanOptions) ([]PodMetrics, error) {
+	pods, err := ma.listPods(opts.Namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	cached := make(map[PodRef]PodMetrics)
+	if stats, ok := ma.statsCache.load(ma.config.StatsCacheTTL); ok {
+		for _, metrics := range stats.Pods {
+			cached[PodRef{Namespace: metrics.Namespace, PodName: metrics.PodName}] = metrics
+		}
+	}
+
+	var result []PodMetrics
+	for _, pod := range pods {
+		if opts.MinSize != nil && ma.isBelowMinSize(pod, *opts.MinSize) {
+			continue
+		}
+		if metrics, ok := cached[PodRef{Namespace: pod.Namespace, PodName: pod.Name}]; ok {
+			result = append(result, metrics)
+			continue
+		}
+		metrics, err := ma.getMetricsForPod(ctx, pod.Name, pod.Namespace, false)
+		if err != nil {
+			log.Printf("Error getting metrics for pod %s: %v", pod.Name, err)
+			continue
+		}
+		result = append(result, metrics)
+	}
+	return result, nil
+}
+
+func (ma *MetricsAnalyzer) formatRecommendation(metrics PodMetrics) string {
+	currentMemMB := metrics.CurrentMemory / (1024 * 1024)
+	maxMemMB := metrics.MaxMemory / (1024 * 1024)
+	recommendMemMB := metrics.RecommendMem / (1024 * 1024)
+
+	cpuDelta := metrics.RecommendCPU - metrics.CurrentCPU
+	memDeltaMB := recommendMemMB - currentMemMB
+	costDelta := (cpuDelta * ma.config.CPUCostPerCore) + (memDeltaMB * ma.config.MemoryCostPerMB)
+
+	var result string
+	result += fmt.Sprintf("Анализ пода: %s\n", metrics.PodName)
+	result += fmt.Sprintf("Namespace: %s\n\n", metrics.Namespace)
+
+	result += "Текущие ресурсы:\n"
+	result += fmt.Sprintf("CPU: %.2f ядер\n", metrics.CurrentCPU)
+	result += fmt.Sprintf("Память: %.2f МБ\n\n", currentMemMB)
+
+	result += "Максимальное использование:\n"
 	result += fmt.Sprintf("CPU: %.2f%%\n", metrics.MaxCPU)
 	result += fmt.Sprintf("Память: %.2f МБ\n\n", maxMemMB)
 
-	result += "Рекомендации:\n"
-	result += fmt.Sprintf("CPU: %.2f ядер (Δ%.2f)\n", metrics.RecommendCPU, cpuDelta)
-	result += fmt.Sprintf("Память: %.2f МБ (Δ%.2f)\n", recommendMemMB, memDeltaMB)
+	result += "Рекомендации:\n"
+	result += fmt.Sprintf("CPU: %.2f ядер (Δ%.2f)\n", metrics.RecommendCPU, cpuDelta)
+	result += fmt.Sprintf("Память: %.2f МБ (Δ%.2f)\n", recommendMemMB, memDeltaMB)
+
+	if metrics.NetworkEgressCost > 0 {
+		result += fmt.Sprintf("\nИсходящий трафик: %.2f %s за 30 дней\n", metrics.NetworkEgressCost, ma.currencyLabel())
+	}
+
+	if costDelta < 0 {
+		result += fmt.Sprintf("\nЭкономия: %.2f %s\n", -costDelta, ma.currencyLabel())
+	} else {
+		result += fmt.Sprintf("\nДополнительные затраты: %.2f %s\n", costDelta, ma.currencyLabel())
+	}
+
+	return result
+}
+
+func main() {
+	config := Config{
+		CPUCostPerCore:  1000.0, // 1000 рублей за ядро
+		MemoryCostPerMB: 0.5,    // 0.5 рублей за МБ
+		Currency:        "RUB",
+		PrometheusURL:   "http://localhost:9090",
+		KubeconfigPath:  "/home/ilinivan/.kube/config",
+
+		BatchWorkers:        10,
+		MaxRequestBodyBytes: 1 << 20,
+
+		CPUUsageMetric:            "container_cpu_usage_seconds_total",
+		MemoryUsageMetric:         "container_memory_usage_bytes",
+		NetworkTransmitMetric:     "container_network_transmit_bytes_total",
+		NodeMemoryTotalMetric:     "node_memory_MemTotal_bytes",
+		NodeMemoryAvailableMetric: "node_memory_MemAvailable_bytes",
+		VolumeUsedMetric:          "kubelet_volume_stats_used_bytes",
+		VolumeCapacityMetric:      "kubelet_volume_stats_capacity_bytes",
+
+		ExcludeContainerRegex: "istio-proxy|istio-init|linkerd-proxy|linkerd-init|envoy",
+
+		PodAggregation: PodAggregationSum,
+
+		RecommendationMode: RecommendationModeMax,
+		HistoryWindow:      12 * time.Hour,
+		QueryStep:          5 * time.Minute,
+		DecayHalfLife:      2 * time.Hour,
+
+		// Круглосуточно. Пн-пт 08:00-20:00 по Москве:
+		// &BusinessHours{StartHour: 8, EndHour: 20, FirstDay: 1, LastDay: 5, UTCOffsetHours: 3}
+		BusinessHours: nil,
+
+		RecommendationDirection: RecommendationDirectionBoth,
+		RecommendResources:      []string{"cpu", "memory", "storage"},
+
+		CPURoundingMillicores: 50,
+		MemoryRoundingMB:      64,
+		CPULimitRequestRatio:  2,
+
+		MemoryAbsoluteBuffer: 128,
+
+		MaxMemoryLimitRequestRatio: 4.0,
+
+		ReplicaAggregationPercentile: 0.95,
+
+		MinAnalyzableCPU:    0.05,
+		MinAnalyzableMemory: 32,
+
+		PriorityMinScore:        0.1,
+		PriorityCriticalSavings: 5000,
+		PriorityHighSavings:     2000,
+		PriorityMediumSavings:   500,
+
+		NodeMetricLabel:         "node",
+		NodePressureLowPercent:  50,
+		NodePressureHighPercent: 85,
+		NodePressureMemoryHeadroom: map[string]float64{
+			NodePressureLow:    1.1,
+			NodePressureNormal: 1.2,
+			NodePressureHigh:   1.5,
+		},
+
+		CPUScoreWeight:    0.5,
+		MemoryScoreWeight: 0.5,
+
+		MaxPodsInResponse: 5000,
+
+		StatsCacheTTL:  5 * time.Minute,
+		SharedCacheURL: os.Getenv("SHARED_CACHE_URL"),
+
+		ScanTimeout: 5 * time.Minute,
+
+		MemoryGrowthThreshold: 0.2,
+
+		LabelAllowlist: []string{"app", "team", "cost-center"},
+
+		TeamLabel: "team",
 
-	if costDelta < 0 {
-		result += fmt.Sprintf("\nЭкономия: %.2f руб.\n", -costDelta)
-	} else {
-		result += fmt.Sprintf("\nДополнительные затраты: %.2f руб.\n", costDelta)
+		StorageCostPerGB:        10.0, // 10 рублей за ГБ
+		PVCUnderutilizedPercent: 50,
+		EgressCostPerGB:         2.0, // 2 рубля за ГБ
+
+		ClusterOverheadFactor: 1.3,
+
+		TLSCertFile: os.Getenv("TLS_CERT_FILE"),
+		TLSKeyFile:  os.Getenv("TLS_KEY_FILE"),
+
+		PrometheusQPS:         20,
+		PrometheusBurst:       10,
+		PrometheusQueryJitter: 50 * time.Millisecond,
+
+		KubernetesQPS:                 5,
+		KubernetesBurst:               10,
+		KubernetesMaxIdleConnsPerHost: 50,
+		PrometheusMaxIdleConnsPerHost: 50,
+		IdleConnTimeout:               90 * time.Second,
+
+		KubernetesThrottleRetries: 5,
+
+		ImpersonateUser:           os.Getenv("IMPERSONATE_USER"),
+		TrustImpersonationHeaders: os.Getenv("TRUST_IMPERSONATION_HEADERS") == "true",
+
+		EnableProfiling: os.Getenv("ENABLE_PROFILING") == "true",
+
+		InformerResyncPeriod: 10 * time.Minute,
+		InformerSyncTimeout:  time.Minute,
+
+		AlertWebhookURL:              os.Getenv("ALERT_WEBHOOK_URL"),
+		AlertClusterSavingsThreshold: 50000,
+		AlertPodSavingsThreshold:     5000,
+		AlertDedupInterval:           24 * time.Hour,
 	}
 
-	return result
-}
+	// FAKE_DATA_PODS=N запускает анализатор на синтетическом кластере из N подов
+	if value := os.Getenv("FAKE_DATA_PODS"); value != "" {
+		pods, err := strconv.Atoi(value)
+		if err != nil {
+			log.Fatalf("Invalid FAKE_DATA_PODS %q: %v", value, err)
+		}
+		config.FakeData = &FakeDataConfig{Pods: pods, Namespaces: 5, Seed: 1, MinUtilization: 0.05, MaxUtilization: 0.9}
+		config.KubeconfigPath = ""
+		log.Printf("Running on fake data with %d pods", pods)
+	}
 
-func main() {
-	config := Config{
-		CPUCostPerCore:  1000.0, // 1000 рублей за ядро
-		MemoryCostPerMB: 0.5,    // 0.5 рублей за МБ
-		PrometheusURL:   "http://localhost:9090",
-		KubeconfigPath:  "/home/ilinivan/.kube/config",
+	if err := config.Validate(); err != nil {
+		log.Fatalf("Invalid configuration:\n%v", err)
 	}
 
-	analyzer, err := NewMetricsAnalyzer(config)
+	clusters, err := newClusterRegistry(config)
 	if err != nil {
 		log.Fatalf("Failed to create metrics analyzer: %v", err)
 	}
 
+	server := &http.Server{Addr: ":8080", Handler: newServeMux(config, clusters)}
+	if config.TLSCertFile != "" {
+		log.Printf("Starting TLS server on :8080")
+		log.Fatal(server.ListenAndServeTLS(config.TLSCertFile, config.TLSKeyFile))
+	}
+
+	log.Printf("Starting server on :8080")
+	log.Fatal(server.ListenAndServe())
+}
+
+// newServeMux регистрирует обработчики API. Обработчики регистрируются в собственном mux:
+// net/http/pprof при импорте добавляет себя в http.DefaultServeMux, а он не должен быть
+// доступен без EnableProfiling.
+func newServeMux(config Config, clusters *clusterRegistry) *http.ServeMux {
+	mux := http.NewServeMux()
+
 	// JSON API
-	http.HandleFunc("/api/metrics", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/api/metrics", clusters.handle(namespaceScope("default"), func(analyzer *MetricsAnalyzer, w http.ResponseWriter, r *http.Request) {
 		namespace := r.URL.Query().Get("namespace")
 		if namespace == "" {
 			namespace = "default"
 		}
 
 		podID := r.URL.Query().Get("pod-id")
+		withPercentiles := r.URL.Query().Get("percentiles") == "true"
+		debug := r.URL.Query().Get("debug") == "true"
+		yamlPatch, err := yamlFormatFromRequest(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		w.Header().Set("Content-Type", "application/json")
 
 		if podID != "" {
-			metrics, err := analyzer.getMetricsForPod(podID, namespace)
+			metrics, err := analyzer.getMetricsForPod(r.Context(), podID, namespace, debug)
 			if err != nil {
 				http.Error(w, fmt.Sprintf("Error getting metrics: %v", err), http.StatusInternalServerError)
 				return
 			}
+			if yamlPatch {
+				patch, err := analyzer.podPatch(metrics)
+				if err != nil {
+					http.Error(w, fmt.Sprintf("Error building patch: %v", err), http.StatusInternalServerError)
+					return
+				}
+				w.Header().Set("Content-Type", yamlContentType)
+				if err := analyzer.writeResourcePatches(w, []resourcePatch{patch}); err != nil {
+					log.Printf("Error writing pod patch: %v", err)
+				}
+				return
+			}
+			if withPercentiles {
+				if err := analyzer.addPercentiles(r.Context(), &metrics, debug); err != nil {
+					http.Error(w, fmt.Sprintf("Error getting percentiles: %v", err), http.StatusInternalServerError)
+					return
+				}
+			}
 			json.NewEncoder(w).Encode(metrics)
 			return
 		}
 
-		pods, err := analyzer.k8sClient.CoreV1().Pods(namespace).List(context.Background(), metav1.ListOptions{})
+		pods, err := analyzer.listPods(namespace)
 		if err != nil {
 			http.Error(w, fmt.Sprintf("Error getting pods: %v", err), http.StatusInternalServerError)
 			return
 		}
 
 		var podMetrics []PodMetrics
-		for _, pod := range pods.Items {
-			metrics, err := analyzer.getMetricsForPod(pod.Name, namespace)
+		for _, pod := range pods {
+			metrics, err := analyzer.getMetricsForPod(r.Context(), pod.Name, namespace, debug)
 			if err != nil {
 				log.Printf("Error getting metrics for pod %s: %v", pod.Name, err)
 				continue
 			}
+			if withPercentiles {
+				if err := analyzer.addPercentiles(r.Context(), &metrics, debug); err != nil {
+					log.Printf("Error getting percentiles for pod %s: %v", pod.Name, err)
+				}
+			}
 			podMetrics = append(podMetrics, metrics)
 		}
 
 		json.NewEncoder(w).Encode(podMetrics)
-	})
+	}))
 
-	// Текстовый API
-	http.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+	// Рекомендация по поду вместе с его последними событиями Kubernetes (OOMKilled, вытеснения, ошибки планирования)
+	mux.HandleFunc("/api/pod-events", clusters.handle(namespaceScope(""), func(analyzer *MetricsAnalyzer, w http.ResponseWriter, r *http.Request) {
 		namespace := r.URL.Query().Get("namespace")
-		if namespace == "" {
-			namespace = "default"
+		podID := r.URL.Query().Get("pod-id")
+		if namespace == "" || podID == "" {
+			http.Error(w, "namespace and pod-id are required", http.StatusBadRequest)
+			return
+		}
+
+		metrics, err := analyzer.getMetricsForPod(r.Context(), podID, namespace, r.URL.Query().Get("debug") == "true")
+		if apierrors.IsNotFound(err) {
+			http.Error(w, fmt.Sprintf("Pod not found: %v", err), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error getting metrics: %v", err), http.StatusInternalServerError)
+			return
+		}
+		client, err := analyzer.clientForRequest(r)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error creating Kubernetes client: %v", err), http.StatusInternalServerError)
+			return
+		}
+		events, err := analyzer.getPodEvents(client, namespace, podID)
+		if apierrors.IsForbidden(err) {
+			http.Error(w, fmt.Sprintf("Error getting events: %v", err), http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error getting events: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(PodEventsResult{Metrics: metrics, Events: events})
+	}))
+
+	// Пакетный анализ заданного списка подов
+	mux.HandleFunc("/api/metrics/batch", clusters.handle(nil, func(analyzer *MetricsAnalyzer, w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, analyzer.config.MaxRequestBodyBytes)
+		var req BatchMetricsRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid request body: %v", err), http.StatusBadRequest)
+			return
+		}
+		if len(req.Pods) == 0 {
+			http.Error(w, "pods list is empty", http.StatusBadRequest)
+			return
+		}
+		namespaces := make([]string, 0, len(req.Pods))
+		for _, ref := range req.Pods {
+			if ref.Namespace == "" || ref.PodName == "" {
+				http.Error(w, "each pod must have namespace and pod_name", http.StatusBadRequest)
+				return
+			}
+			namespaces = append(namespaces, ref.Namespace)
+		}
+		if err := analyzer.authorize(r, namespaces...); err != nil {
+			writeAuthorizationError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(analyzer.getMetricsForPods(r.Context(), req.Pods))
+	}))
+
+	// Стоимость пода при произвольных ресурсах, без изменений в кластере
+	mux.HandleFunc("/api/whatif", clusters.handle(nil, func(analyzer *MetricsAnalyzer, w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, analyzer.config.MaxRequestBodyBytes)
+		var req WhatIfRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid request body: %v", err), http.StatusBadRequest)
+			return
+		}
+		if req.Namespace == "" || req.PodName == "" {
+			http.Error(w, "namespace and pod_name are required", http.StatusBadRequest)
+			return
+		}
+		if err := analyzer.authorize(r, req.Namespace); err != nil {
+			writeAuthorizationError(w, err)
+			return
+		}
+
+		result, err := analyzer.whatIf(req)
+		if apierrors.IsNotFound(err) {
+			http.Error(w, fmt.Sprintf("Pod not found: %v", err), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error calculating cost: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	}))
+
+	// Проверка предложенных ресурсов по пиковому использованию с момента расчета рекомендации
+	mux.HandleFunc("/api/recommendation/validate", clusters.handle(nil, func(analyzer *MetricsAnalyzer, w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, analyzer.config.MaxRequestBodyBytes)
+		var req ValidateRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid request body: %v", err), http.StatusBadRequest)
+			return
+		}
+		if req.Namespace == "" || req.PodName == "" {
+			http.Error(w, "namespace and pod_name are required", http.StatusBadRequest)
+			return
+		}
+		if err := analyzer.authorize(r, req.Namespace); err != nil {
+			writeAuthorizationError(w, err)
+			return
+		}
+
+		result, err := analyzer.validateRecommendation(r.Context(), req)
+		if apierrors.IsNotFound(err) {
+			http.Error(w, fmt.Sprintf("Pod not found: %v", err), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error validating recommendation: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	}))
+
+	// Текстовый API
+	// Устаревший текстовый вариант /api/cluster-stats: принимает namespace (по умолчанию default)
+	// и min_size и выводит поды так же, как до перехода на ClusterStats (см. getLegacyPodMetrics).
+	// Оставлен на время перехода клиентов на /api/cluster-stats.
+	mux.HandleFunc("/metrics", clusters.handle(namespaceScope("default"), func(analyzer *MetricsAnalyzer, w http.ResponseWriter, r *http.Request) {
+		opts, err := scanOptionsFromRequest(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if opts.Namespace == "" {
+			opts.Namespace = "default"
 		}
 
 		podID := r.URL.Query().Get("pod-id")
 		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", `</api/cluster-stats>; rel="successor-version"`)
 
 		if podID != "" {
-			metrics, err := analyzer.getMetricsForPod(podID, namespace)
+			metrics, err := analyzer.getMetricsForPod(r.Context(), podID, opts.Namespace, false)
 			if err != nil {
 				http.Error(w, fmt.Sprintf("Ошибка получения метрик: %v", err), http.StatusInternalServerError)
 				return
@@ -325,43 +2136,331 @@ func main() {
 			return
 		}
 
-		pods, err := analyzer.k8sClient.CoreV1().Pods(namespace).List(context.Background(), metav1.ListOptions{})
+		pods, err := analyzer.getLegacyPodMetrics(r.Context(), opts)
 		if err != nil {
 			http.Error(w, fmt.Sprintf("Ошибка получения списка подов: %v", err), http.StatusInternalServerError)
 			return
 		}
 
 		var response string
-		for _, pod := range pods.Items {
-			metrics, err := analyzer.getMetricsForPod(pod.Name, namespace)
-			if err != nil {
-				log.Printf("Error getting metrics for pod %s: %v", pod.Name, err)
-				continue
-			}
+		for _, metrics := range pods {
 			response += analyzer.formatRecommendation(metrics) + "\n---\n\n"
 		}
 
 		fmt.Fprint(w, response)
-	})
+	}))
 
 	// API для статистики кластера
-	http.HandleFunc("/api/cluster-stats", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/api/cluster-stats", clusters.handle(namespaceScope(""), func(analyzer *MetricsAnalyzer, w http.ResponseWriter, r *http.Request) {
 		log.Printf("Received request for cluster stats")
+		opts, err := scanOptionsFromRequest(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		sortBy, err := sortByFromRequest(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		direction, err := directionFromRequest(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		w.Header().Set("Content-Type", "application/json")
-		stats, err := analyzer.getClusterStats()
+		if r.URL.Query().Get("streaming") == "true" {
+			if err := checkStreamingParams(r, opts); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			analyzer.streamClusterStats(w, opts)
+			return
+		}
+		weights, err := analyzer.scoreWeightsFromRequest(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var stats ClusterStats
+		if weights != nil {
+			stats, err = analyzer.getReweightedClusterStats(opts, *weights)
+		} else {
+			stats, err = analyzer.getClusterStats(opts)
+		}
 		if err != nil {
 			log.Printf("Error getting cluster stats: %v", err)
 			http.Error(w, fmt.Sprintf("Error getting cluster stats: %v", err), http.StatusInternalServerError)
 			return
 		}
+		// Фильтр по направлению меняет только список подов, итоги остаются по всему кластеру
+		stats.Pods = filterPodsByDirection(stats.Pods, direction)
+		if sortBy == SortBySavings {
+			stats.Pods = sortPodsBySavings(stats.Pods)
+		}
+		stats.Pods, stats.Truncated = limitPods(stats.Pods, analyzer.config.MaxPodsInResponse)
 		log.Printf("Sending cluster stats response")
 		if err := json.NewEncoder(w).Encode(stats); err != nil {
 			log.Printf("Error encoding cluster stats: %v", err)
 			http.Error(w, fmt.Sprintf("Error encoding cluster stats: %v", err), http.StatusInternalServerError)
 			return
 		}
+	}))
+
+	// Сводный отчет для руководства: самодостаточная HTML-страница для рассылки или печати в PDF
+	mux.HandleFunc("/api/report", clusters.handle(namespaceScope(""), func(analyzer *MetricsAnalyzer, w http.ResponseWriter, r *http.Request) {
+		if format := r.URL.Query().Get("format"); format != "" && format != "html" {
+			http.Error(w, fmt.Sprintf("unsupported report format %q, only html is available", format), http.StatusBadRequest)
+			return
+		}
+		topPods := defaultReportTopPods
+		if value := r.URL.Query().Get("top"); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n <= 0 {
+				http.Error(w, fmt.Sprintf("top must be a positive integer, got %q", value), http.StatusBadRequest)
+				return
+			}
+			topPods = n
+		}
+		opts, err := scanOptionsFromRequest(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		stats, err := analyzer.getClusterStats(opts)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error getting cluster stats: %v", err), http.StatusInternalServerError)
+			return
+		}
+		var buf bytes.Buffer
+		if err := analyzer.writeHTMLReport(&buf, stats, topPods); err != nil {
+			http.Error(w, fmt.Sprintf("Error rendering report: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(buf.Bytes())
+	}))
+
+	// Результат последнего полного сканирования для ведомых реплик (см. SharedCacheURL)
+	mux.HandleFunc("/api/cluster-stats/cached", clusters.handle(clusterScope, func(analyzer *MetricsAnalyzer, w http.ResponseWriter, r *http.Request) {
+		stats, err := analyzer.getCachedClusterStats()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error getting cluster stats: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(stats)
+	}))
+
+	// Рекомендации в формате экспозиции Prometheus для сбора обратно в Prometheus
+	mux.HandleFunc("/api/recommendations/prometheus", clusters.handle(namespaceScope(""), func(analyzer *MetricsAnalyzer, w http.ResponseWriter, r *http.Request) {
+		opts, err := scanOptionsFromRequest(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		stats, err := analyzer.getClusterStats(opts)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error getting cluster stats: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", expositionContentType)
+		analyzer.writeRecommendationsExposition(w, stats)
+	}))
+
+	// Поды с подозрительной конфигурацией памяти: нет лимита, нет запроса или слишком большое отношение лимита к запросу
+	mux.HandleFunc("/api/misconfigurations", clusters.handle(namespaceScope(""), func(analyzer *MetricsAnalyzer, w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		misconfigurations, err := analyzer.findMisconfigurations(r.URL.Query().Get("namespace"))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error getting pods: %v", err), http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(misconfigurations)
+	}))
+
+	// Поды без запросов и лимитов с рекомендуемыми по использованию значениями
+	mux.HandleFunc("/api/unbounded-pods", clusters.handle(namespaceScope(""), func(analyzer *MetricsAnalyzer, w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		pods, err := analyzer.findUnboundedPods(r.URL.Query().Get("namespace"))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error getting pods: %v", err), http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(pods)
+	}))
+
+	// Ресурсы контейнеров с лимитом без запроса или запросом без лимита и значения для отсутствующих полей
+	mux.HandleFunc("/api/missing-requests", clusters.handle(namespaceScope(""), func(analyzer *MetricsAnalyzer, w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		missing, err := analyzer.findMissingRequests(r.URL.Query().Get("namespace"))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error getting pods: %v", err), http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(missing)
+	}))
+
+	// Заполненность и стоимость PersistentVolumeClaim
+	mux.HandleFunc("/api/pvc-usage", clusters.handle(namespaceScope(""), func(analyzer *MetricsAnalyzer, w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		usage, err := analyzer.getPVCUsage(r.URL.Query().Get("namespace"))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error getting PVC usage: %v", err), http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(usage)
+	}))
+
+	// Зарезервированные ресурсы Deployment/StatefulSet, включая нагрузки без запущенных подов
+	mux.HandleFunc("/api/workloads", clusters.handle(namespaceScope(""), func(analyzer *MetricsAnalyzer, w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		workloads, err := analyzer.getWorkloadResources(r.URL.Query().Get("namespace"))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error getting workloads: %v", err), http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(workloads)
+	}))
+
+	// Метрики и стоимость группы подов по селектору меток во всех namespace, например ?selector=team=checkout
+	mux.HandleFunc("/api/group-metrics", clusters.handle(clusterScope, func(analyzer *MetricsAnalyzer, w http.ResponseWriter, r *http.Request) {
+		selector, err := parseGroupSelector(r.URL.Query().Get("selector"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		opts, err := scanOptionsFromRequest(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		group, err := analyzer.getGroupMetrics(selector, opts)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error getting group metrics: %v", err), http.StatusInternalServerError)
+			return
+		}
+		group.Pods, group.Truncated = limitPods(group.Pods, analyzer.config.MaxPodsInResponse)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(group)
+	}))
+
+	// Рейтинг namespace, команд или рабочих нагрузок по экономии, ?group_by= (по умолчанию namespace)
+	mux.HandleFunc("/api/leaderboard", clusters.handle(clusterScope, func(analyzer *MetricsAnalyzer, w http.ResponseWriter, r *http.Request) {
+		groupBy, err := groupByFromRequest(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		leaderboard, err := analyzer.getLeaderboard(groupBy)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error getting leaderboard: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(leaderboard)
+	}))
+
+	// Контейнеры кластера с наибольшей экономией, ?limit= (по умолчанию 20)
+	mux.HandleFunc("/api/hotspots", clusters.handle(clusterScope, func(analyzer *MetricsAnalyzer, w http.ResponseWriter, r *http.Request) {
+		limit := defaultHotspotsLimit
+		if value := r.URL.Query().Get("limit"); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed <= 0 {
+				http.Error(w, fmt.Sprintf("invalid limit %q", value), http.StatusBadRequest)
+				return
+			}
+			limit = parsed
+		}
+		hotspots, err := analyzer.getHotspots(limit)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error getting hotspots: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(hotspots)
+	}))
+
+	// Рекомендации на уровне шаблона рабочей нагрузки, сведенные по всем репликам
+	mux.HandleFunc("/api/workload-recommendations", clusters.handle(namespaceScope(""), func(analyzer *MetricsAnalyzer, w http.ResponseWriter, r *http.Request) {
+		yamlPatch, err := yamlFormatFromRequest(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		recommendations, err := analyzer.getWorkloadRecommendations(r.URL.Query().Get("namespace"))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error getting workload recommendations: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if yamlPatch {
+			w.Header().Set("Content-Type", yamlContentType)
+			if err := analyzer.writeResourcePatches(w, analyzer.workloadPatches(recommendations)); err != nil {
+				log.Printf("Error writing workload patches: %v", err)
+			}
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(recommendations)
+	}))
+
+	// Доступные контексты kubeconfig, выбираются параметром ?cluster= на остальных эндпоинтах
+	mux.HandleFunc("/api/clusters", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(clusters.list())
 	})
 
-	log.Printf("Starting server on :8080")
-	log.Fatal(http.ListenAndServe(":8080", nil))
+	// Версия сборки и кластер, к которому подключен анализатор. Данных подов не раскрывает,
+	// поэтому доступна без проверки прав пользователя из заголовков имперсонации
+	mux.HandleFunc("/api/version", clusters.handle(nil, func(analyzer *MetricsAnalyzer, w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(analyzer.getVersionInfo())
+	}))
+
+	// Сканирование кластера с прогрессом через Server-Sent Events:
+	// события progress ({"processed": N, "total": M}) и итоговое событие result со статистикой
+	mux.HandleFunc("/api/cluster-stats/stream", clusters.handle(clusterScope, func(analyzer *MetricsAnalyzer, w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+
+		writeEvent := func(event string, data interface{}) {
+			payload, err := json.Marshal(data)
+			if err != nil {
+				log.Printf("Error encoding %s event: %v", event, err)
+				return
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload)
+			flusher.Flush()
+		}
+
+		stats, err := analyzer.getClusterStats(ScanOptions{
+			Progress: func(processed, total int) {
+				writeEvent("progress", map[string]int{"processed": processed, "total": total})
+			},
+		})
+		if err != nil {
+			log.Printf("Error getting cluster stats: %v", err)
+			writeEvent("error", map[string]string{"error": err.Error()})
+			return
+		}
+		writeEvent("result", stats)
+	}))
+
+	if config.EnableProfiling {
+		log.Printf("Profiling endpoints enabled under /debug/pprof/")
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	}
+	return mux
 }
@@ -0,0 +1,488 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// testConfig - конфигурация по умолчанию из main без округления рекомендаций, чтобы ожидаемые
+// значения в тестах считались по использованию напрямую
+func testConfig() Config {
+	return Config{
+		CPUCostPerCore:  1000,
+		MemoryCostPerMB: 0.5,
+		Currency:        "RUB",
+
+		BatchWorkers: 1,
+
+		CPUUsageMetric:            "container_cpu_usage_seconds_total",
+		MemoryUsageMetric:         "container_memory_usage_bytes",
+		NetworkTransmitMetric:     "container_network_transmit_bytes_total",
+		NodeMemoryTotalMetric:     "node_memory_MemTotal_bytes",
+		NodeMemoryAvailableMetric: "node_memory_MemAvailable_bytes",
+		VolumeUsedMetric:          "kubelet_volume_stats_used_bytes",
+		VolumeCapacityMetric:      "kubelet_volume_stats_capacity_bytes",
+
+		PodAggregation:     PodAggregationSum,
+		RecommendationMode: RecommendationModeMax,
+		HistoryWindow:      12 * time.Hour,
+		QueryStep:          5 * time.Minute,
+
+		RecommendationDirection: RecommendationDirectionBoth,
+
+		ReplicaAggregationPercentile: 0.95,
+
+		NodeMetricLabel: "node",
+
+		CPUScoreWeight:    0.5,
+		MemoryScoreWeight: 0.5,
+
+		PrometheusRetention:   90 * 24 * time.Hour,
+		ClusterOverheadFactor: 1,
+	}
+}
+
+// newTestAnalyzer возвращает анализатор поверх клиента Kubernetes в памяти с объектами objects
+func newTestAnalyzer(t *testing.T, config Config, promClient v1.API, objects ...runtime.Object) *MetricsAnalyzer {
+	t.Helper()
+	analyzer := &MetricsAnalyzer{
+		promClient: promClient,
+		k8sClient:  fake.NewSimpleClientset(objects...),
+		config:     config,
+		alertDedup: newAlertDeduplicator(config.AlertDedupInterval),
+	}
+	if err := analyzer.startInformers(); err != nil {
+		t.Fatalf("startInformers: %v", err)
+	}
+	t.Cleanup(func() { close(analyzer.stopCh) })
+	return analyzer
+}
+
+// containerUsage - пиковое использование контейнера: CPU в процентах ядра, память в байтах
+type containerUsage struct {
+	cpu    float64
+	memory float64
+}
+
+// seriesPrometheus отдает по ряду на контейнер пода и сводит их той агрегацией, которой
+// запрос сворачивает ряды пода (sum или max). Остальные запросы возвращают 0.
+type seriesPrometheus struct {
+	v1.API
+	config     Config
+	containers map[string][]containerUsage // По ключу namespace/pod
+}
+
+var seriesPodSelector = regexp.MustCompile(`pod="([^"]+)",namespace="([^"]+)"`)
+
+func (sp *seriesPrometheus) value(query string) float64 {
+	match := seriesPodSelector.FindStringSubmatch(query)
+	if match == nil {
+		return 0
+	}
+	containers := sp.containers[match[2]+"/"+match[1]]
+
+	var usage func(containerUsage) float64
+	var aggregation string
+	if m := regexp.MustCompile(`(sum|max)\(rate\(` + sp.config.CPUUsageMetric).FindStringSubmatch(query); m != nil {
+		usage, aggregation = func(c containerUsage) float64 { return c.cpu }, m[1]
+	} else if m := regexp.MustCompile(`(sum|max)\(` + sp.config.MemoryUsageMetric).FindStringSubmatch(query); m != nil {
+		usage, aggregation = func(c containerUsage) float64 { return c.memory }, m[1]
+	} else {
+		return 0
+	}
+
+	var result float64
+	for _, container := range containers {
+		if aggregation == PodAggregationMax {
+			result = max(result, usage(container))
+		} else {
+			result += usage(container)
+		}
+	}
+	return result
+}
+
+func (sp *seriesPrometheus) Query(ctx context.Context, query string, ts time.Time, opts ...v1.Option) (model.Value, v1.Warnings, error) {
+	return model.Vector{&model.Sample{Value: model.SampleValue(sp.value(query)), Timestamp: model.TimeFromUnixNano(ts.UnixNano())}}, nil, nil
+}
+
+// QueryRange возвращает постоянный ряд со значением value(query) на каждом шаге диапазона
+func (sp *seriesPrometheus) QueryRange(ctx context.Context, query string, r v1.Range, opts ...v1.Option) (model.Value, v1.Warnings, error) {
+	stream := &model.SampleStream{Metric: model.Metric{}}
+	for ts := r.Start; !ts.After(r.End); ts = ts.Add(r.Step) {
+		stream.Values = append(stream.Values, model.SamplePair{Timestamp: model.TimeFromUnixNano(ts.UnixNano()), Value: model.SampleValue(sp.value(query))})
+	}
+	return model.Matrix{stream}, nil, nil
+}
+
+// testPod возвращает запущенный под с контейнерами с указанными лимитами CPU и памяти
+func testPod(namespace, name string, started time.Time, limits ...corev1.ResourceList) *corev1.Pod {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, CreationTimestamp: metav1.NewTime(started)},
+		Status:     corev1.PodStatus{Phase: corev1.PodRunning, StartTime: &metav1.Time{Time: started}},
+	}
+	for i, limit := range limits {
+		pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{
+			Name:      fmt.Sprintf("c%d", i),
+			Resources: corev1.ResourceRequirements{Requests: limit, Limits: limit},
+		})
+	}
+	return pod
+}
+
+func resources(cpu, memory string) corev1.ResourceList {
+	return corev1.ResourceList{
+		corev1.ResourceCPU:    resource.MustParse(cpu),
+		corev1.ResourceMemory: resource.MustParse(memory),
+	}
+}
+
+func TestPodAggregationTwoContainers(t *testing.T) {
+	const mb = 1024 * 1024
+	pod := testPod("default", "web", time.Now().Add(-24*time.Hour), resources("1", "1Gi"), resources("1", "1Gi"))
+	usage := map[string][]containerUsage{
+		"default/web": {{cpu: 30, memory: 200 * mb}, {cpu: 50, memory: 100 * mb}},
+	}
+
+	tests := []struct {
+		aggregation string
+		wantCPU     float64
+		wantMemory  float64
+	}{
+		{PodAggregationSum, 80, 300 * mb},
+		{PodAggregationMax, 50, 200 * mb},
+	}
+	for _, tt := range tests {
+		t.Run(tt.aggregation, func(t *testing.T) {
+			config := testConfig()
+			config.PodAggregation = tt.aggregation
+			analyzer := newTestAnalyzer(t, config, &seriesPrometheus{config: config, containers: usage}, pod)
+
+			metrics, err := analyzer.getMetricsForPod(context.Background(), "web", "default", false)
+			if err != nil {
+				t.Fatalf("getMetricsForPod: %v", err)
+			}
+			if metrics.MaxCPU != tt.wantCPU {
+				t.Errorf("MaxCPU = %g, want %g", metrics.MaxCPU, tt.wantCPU)
+			}
+			if metrics.MaxMemory != tt.wantMemory {
+				t.Errorf("MaxMemory = %g, want %g", metrics.MaxMemory, tt.wantMemory)
+			}
+			if metrics.RecommendCPU != tt.wantCPU/100 {
+				t.Errorf("RecommendCPU = %g, want %g", metrics.RecommendCPU, tt.wantCPU/100)
+			}
+		})
+	}
+}
+
+func TestIsBelowMinSizeSingleThreshold(t *testing.T) {
+	analyzer := &MetricsAnalyzer{config: testConfig()}
+	small := testPod("default", "small", time.Now(), resources("50m", "1Gi"))
+	// Лимит большой, но запрос маленький: сравниваются запросы
+	burstable := testPod("default", "burstable", time.Now())
+	burstable.Spec.Containers = []corev1.Container{{
+		Name:      "app",
+		Resources: corev1.ResourceRequirements{Requests: resources("50m", "64Mi"), Limits: resources("2", "2Gi")},
+	}}
+	large := testPod("default", "large", time.Now(), resources("500m", "64Mi"))
+
+	tests := []struct {
+		name    string
+		pod     *corev1.Pod
+		minSize ResourceSize
+		want    bool
+	}{
+		{"cpu only, below", small, ResourceSize{CPU: 0.1}, true},
+		{"cpu only, above", large, ResourceSize{CPU: 0.1}, false},
+		{"memory only, below", large, ResourceSize{Memory: 128 * 1024 * 1024}, true},
+		{"memory only, above", small, ResourceSize{Memory: 128 * 1024 * 1024}, false},
+		{"requests, not limits", burstable, ResourceSize{CPU: 0.1, Memory: 128 * 1024 * 1024}, true},
+		{"both, one above", small, ResourceSize{CPU: 0.1, Memory: 128 * 1024 * 1024}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := analyzer.isBelowMinSize(tt.pod, tt.minSize); got != tt.want {
+				t.Errorf("isBelowMinSize = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClusterUtilizationAgainstRequests(t *testing.T) {
+	const mb = 1024 * 1024
+	namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}}
+	started := time.Now().Add(-24 * time.Hour)
+	requested := testPod("default", "requested", started)
+	requested.Spec.Containers = []corev1.Container{{
+		Name:      "app",
+		Resources: corev1.ResourceRequirements{Requests: resources("1", "1Gi"), Limits: resources("4", "4Gi")},
+	}}
+	// Под без запросов не должен завышать процент использованием, которое не с чем сравнить
+	unbounded := testPod("default", "unbounded", started)
+	unbounded.Spec.Containers = []corev1.Container{{Name: "app"}}
+	usage := map[string][]containerUsage{
+		"default/requested": {{cpu: 25, memory: 256 * mb}},
+		"default/unbounded": {{cpu: 300, memory: 2048 * mb}},
+	}
+
+	config := testConfig()
+	analyzer := newTestAnalyzer(t, config, &seriesPrometheus{config: config, containers: usage}, namespace, requested, unbounded)
+	stats, err := analyzer.getClusterStats(ScanOptions{})
+	if err != nil {
+		t.Fatalf("getClusterStats: %v", err)
+	}
+	if stats.TotalPods != 2 {
+		t.Fatalf("TotalPods = %d, want both pods analyzed", stats.TotalPods)
+	}
+	if stats.CPUUtilizationPercent != 25 {
+		t.Errorf("CPUUtilizationPercent = %g, want 25", stats.CPUUtilizationPercent)
+	}
+	if stats.MemoryUtilizationPercent != 25 {
+		t.Errorf("MemoryUtilizationPercent = %g, want 25", stats.MemoryUtilizationPercent)
+	}
+}
+
+// recordingPrometheus запоминает выполненные запросы
+type recordingPrometheus struct {
+	seriesPrometheus
+	mu      sync.Mutex
+	queries []string
+}
+
+func (rp *recordingPrometheus) Query(ctx context.Context, query string, ts time.Time, opts ...v1.Option) (model.Value, v1.Warnings, error) {
+	rp.mu.Lock()
+	rp.queries = append(rp.queries, query)
+	rp.mu.Unlock()
+	return rp.seriesPrometheus.Query(ctx, query, ts, opts...)
+}
+
+// QueryRange запоминает запрос вместе с длиной диапазона
+func (rp *recordingPrometheus) QueryRange(ctx context.Context, query string, r v1.Range, opts ...v1.Option) (model.Value, v1.Warnings, error) {
+	rp.mu.Lock()
+	rp.queries = append(rp.queries, query+" over "+model.Duration(r.End.Sub(r.Start)).String())
+	rp.mu.Unlock()
+	return rp.seriesPrometheus.QueryRange(ctx, query, r, opts...)
+}
+
+func TestQueriesClampWindowToRetention(t *testing.T) {
+	config := testConfig()
+	config.HistoryWindow = 12 * time.Hour
+	config.PrometheusRetention = 6 * time.Hour
+	pod := testPod("default", "web", time.Now().Add(-24*time.Hour), resources("1", "1Gi"))
+	prom := &recordingPrometheus{seriesPrometheus: seriesPrometheus{config: config}}
+	analyzer := newTestAnalyzer(t, config, prom, pod)
+
+	metrics, err := analyzer.getMetricsForPod(context.Background(), "web", "default", false)
+	if err != nil {
+		t.Fatalf("getMetricsForPod: %v", err)
+	}
+	if err := analyzer.addPercentiles(context.Background(), &metrics, false); err != nil {
+		t.Fatalf("addPercentiles: %v", err)
+	}
+	for _, query := range prom.queries {
+		if strings.Contains(query, "12h") {
+			t.Errorf("query uses the unclamped window: %s", query)
+		}
+	}
+	if len(metrics.Warnings) == 0 {
+		t.Error("no warning about the window exceeding Prometheus retention")
+	}
+}
+
+func TestRecommendCPURequestAndLimit(t *testing.T) {
+	// Использование 1%..100% ядра: p95 = 0.9505, p99 = 0.9901 ядра
+	var usage []model.SamplePair
+	for i := 1; i <= 100; i++ {
+		usage = append(usage, model.SamplePair{Timestamp: model.Time(i * 60000), Value: model.SampleValue(i)})
+	}
+
+	tests := []struct {
+		name                         string
+		direction                    string
+		ratio                        float64
+		currentRequest, currentLimit float64
+		wantRequest, wantLimit       float64
+	}{
+		{"p95 request, p99 limit", RecommendationDirectionBoth, 0, 0.5, 1, 0.9505, 0.9901},
+		{"limit as a multiple of request", RecommendationDirectionBoth, 2, 0.5, 1, 0.9505, 1.901},
+		{"decrease only", RecommendationDirectionDecrease, 2, 0.5, 1, 0.5, 1},
+		{"increase only", RecommendationDirectionIncrease, 2, 2, 4, 2, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig()
+			config.RecommendationDirection = tt.direction
+			config.CPULimitRequestRatio = tt.ratio
+			analyzer := &MetricsAnalyzer{config: config}
+
+			request, limit := analyzer.recommendCPURequestAndLimit(usage, tt.currentRequest, tt.currentLimit, 0)
+			if math.Abs(request-tt.wantRequest) > 1e-9 || math.Abs(limit-tt.wantLimit) > 1e-9 {
+				t.Errorf("request, limit = %g, %g, want %g, %g", request, limit, tt.wantRequest, tt.wantLimit)
+			}
+		})
+	}
+}
+
+func TestConfidenceFromUsageSeries(t *testing.T) {
+	config := testConfig()
+	analyzer := &MetricsAnalyzer{config: config}
+	pod := testPod("default", "web", time.Now().Add(-2*config.HistoryWindow), resources("1", "1Gi"))
+	expected := int(config.HistoryWindow / config.QueryStep)
+	series := func(points int, value func(i int) float64) []model.SamplePair {
+		var usage []model.SamplePair
+		for i := 0; i < points; i++ {
+			usage = append(usage, model.SamplePair{Value: model.SampleValue(value(i))})
+		}
+		return usage
+	}
+
+	tests := []struct {
+		name  string
+		usage []model.SamplePair
+		want  float64
+	}{
+		{"full and stable", series(expected, func(int) float64 { return 10 }), 1},
+		{"half the points", series(expected/2, func(int) float64 { return 10 }), 0.5},
+		// Среднее 10, отклонение 10: стабильность 1/2, доверие 0.75
+		{"unstable", series(expected, func(i int) float64 { return float64(i%2) * 20 }), 0.75},
+		{"no data", nil, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := analyzer.getConfidence(pod, tt.usage, config.HistoryWindow); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("confidence = %g, want %g", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLegacyPodMetricsKeepsPodSetAndUsesCache(t *testing.T) {
+	started := time.Now().Add(-24 * time.Hour)
+	web := testPod("default", "a-web", started, resources("1", "1Gi"))
+	skipped := testPod("default", "b-skipped", started, resources("1", "1Gi"))
+	skipped.Annotations = map[string]string{SkipOptimizationAnnotation: "true"}
+	failed := testPod("default", "c-failed", started, resources("1", "1Gi"))
+	failed.Status.Phase = corev1.PodFailed
+
+	config := testConfig()
+	config.StatsCacheTTL = time.Minute
+	config.MinAnalyzableCPU = 10
+	prom := &recordingPrometheus{seriesPrometheus: seriesPrometheus{config: config}}
+	analyzer := newTestAnalyzer(t, config, prom, failed, web, skipped)
+	analyzer.statsCache.store(ClusterStats{Pods: []PodMetrics{{PodName: "a-web", Namespace: "default", OptimizationScore: 42}}})
+
+	pods, err := analyzer.getLegacyPodMetrics(context.Background(), ScanOptions{Namespace: "default"})
+	if err != nil {
+		t.Fatalf("getLegacyPodMetrics: %v", err)
+	}
+	var names []string
+	for _, metrics := range pods {
+		names = append(names, metrics.PodName)
+	}
+	if strings.Join(names, ",") != "a-web,b-skipped,c-failed" {
+		t.Fatalf("pods = %v, want every pod of the namespace in name order", names)
+	}
+	if pods[0].OptimizationScore != 42 {
+		t.Errorf("a-web score = %g, want the cached 42", pods[0].OptimizationScore)
+	}
+	for _, query := range prom.queries {
+		if strings.Contains(query, `pod="a-web"`) {
+			t.Errorf("cached pod was queried again: %s", query)
+		}
+	}
+}
+
+func TestFollowerReusesLocalStatsCache(t *testing.T) {
+	var fetches atomic.Int32
+	leader := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		json.NewEncoder(w).Encode(ClusterStats{TotalPods: 3})
+	}))
+	defer leader.Close()
+
+	config := testConfig()
+	config.SharedCacheURL = leader.URL
+	config.StatsCacheTTL = time.Minute
+	analyzer := newTestAnalyzer(t, config, &seriesPrometheus{config: config})
+	for i := 0; i < 3; i++ {
+		stats, err := analyzer.getClusterStats(ScanOptions{})
+		if err != nil {
+			t.Fatalf("getClusterStats: %v", err)
+		}
+		if stats.TotalPods != 3 {
+			t.Fatalf("TotalPods = %d, want the leader's 3", stats.TotalPods)
+		}
+	}
+	if fetches.Load() != 1 {
+		t.Errorf("leader fetched %d times, want 1 while the local cache is fresh", fetches.Load())
+	}
+}
+
+// constantPrometheus отвечает на любой запрос одним и тем же значением
+type constantPrometheus struct {
+	v1.API
+	value float64
+}
+
+func (cp *constantPrometheus) Query(ctx context.Context, query string, ts time.Time, opts ...v1.Option) (model.Value, v1.Warnings, error) {
+	return model.Vector{&model.Sample{Value: model.SampleValue(cp.value)}}, nil, nil
+}
+
+func TestNodePressureAgainstCapacity(t *testing.T) {
+	const gb = 1024 * 1024 * 1024
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1"},
+		Status: corev1.NodeStatus{
+			Capacity:    corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("8Gi")},
+			Allocatable: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("6Gi")},
+		},
+	}
+
+	tests := []struct {
+		name string
+		used float64
+		want float64
+	}{
+		// С allocatable вышло бы 116%
+		{"above allocatable", 7 * gb, 87.5},
+		{"clamped to capacity", 9 * gb, 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			analyzer := newTestAnalyzer(t, testConfig(), &constantPrometheus{value: tt.used}, node)
+			pressure, err := analyzer.getNodePressure(context.Background(), "node-1", &queryLog{})
+			if err != nil {
+				t.Fatalf("getNodePressure: %v", err)
+			}
+			if pressure.MemoryPercent != tt.want {
+				t.Errorf("MemoryPercent = %g, want %g", pressure.MemoryPercent, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateRejectsHeadroomDecreasingWithPressure(t *testing.T) {
+	config := testConfig()
+	config.NodePressureMemoryHeadroom = map[string]float64{NodePressureLow: 1.3, NodePressureNormal: 1.2, NodePressureHigh: 1.5}
+	if err := config.Validate(); err == nil || !strings.Contains(err.Error(), "must not decrease with pressure") {
+		t.Errorf("Validate() = %v, want an error about headroom decreasing with pressure", err)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Ресурс контейнера задан только наполовину. Без запроса планировщик считает, что контейнеру
+// ничего не нужно, и переполняет узлы; без лимита контейнер может занять весь узел.
+const (
+	ReasonMissingRequest = "limit set without request"
+	ReasonMissingLimit   = "request set without limit"
+)
+
+// MissingResource - ресурс контейнера, у которого задан лимит без запроса или запрос без лимита,
+// и значение для отсутствующего поля по наблюдаемому использованию контейнера
+type MissingResource struct {
+	Namespace   string  `json:"namespace"`
+	PodName     string  `json:"pod_name"`
+	Container   string  `json:"container"`
+	Resource    string  `json:"resource"` // cpu или memory
+	Missing     string  `json:"missing"`  // request или limit
+	Current     float64 `json:"current"`  // Заданное поле: ядра или байты
+	Recommended float64 `json:"recommended"`
+	Reason      string  `json:"reason"`
+}
+
+// missingResources находит ресурсы анализируемых контейнеров, заданные только наполовину.
+// Рекомендации в результате не заполняются.
+func (ma *MetricsAnalyzer) missingResources(pod *corev1.Pod) []MissingResource {
+	var result []MissingResource
+	for _, container := range ma.declaredPodSpec(pod).Containers {
+		if ma.isContainerExcluded(container.Name) {
+			continue
+		}
+		for _, name := range []corev1.ResourceName{corev1.ResourceCPU, corev1.ResourceMemory} {
+			request, hasRequest := container.Resources.Requests[name]
+			limit, hasLimit := container.Resources.Limits[name]
+			missing := MissingResource{Namespace: pod.Namespace, PodName: pod.Name, Container: container.Name, Resource: string(name)}
+			switch {
+			case hasLimit && !hasRequest:
+				missing.Missing, missing.Current = "request", limit.AsApproximateFloat64()
+				missing.Reason = fmt.Sprintf("%s: container %s %s", ReasonMissingRequest, container.Name, name)
+			case hasRequest && !hasLimit:
+				missing.Missing, missing.Current = "limit", request.AsApproximateFloat64()
+				missing.Reason = fmt.Sprintf("%s: container %s %s", ReasonMissingLimit, container.Name, name)
+			default:
+				continue
+			}
+			result = append(result, missing)
+		}
+	}
+	return result
+}
+
+// declaredPodSpec возвращает спецификацию пода в том виде, в каком ее задали. API-сервер
+// подставляет в под запрос, равный лимиту, если запрос не задан, поэтому отсутствующий запрос
+// виден только в шаблоне Deployment или StatefulSet. Для остальных подов берется сам под.
+func (ma *MetricsAnalyzer) declaredPodSpec(pod *corev1.Pod) corev1.PodSpec {
+	switch kind, name := ma.resolveOwner(pod); kind {
+	case "Deployment":
+		if deployment, err := ma.deploymentLister.Deployments(pod.Namespace).Get(name); err == nil {
+			return deployment.Spec.Template.Spec
+		}
+	case "StatefulSet":
+		if statefulSet, err := ma.statefulSetLister.StatefulSets(pod.Namespace).Get(name); err == nil {
+			return statefulSet.Spec.Template.Spec
+		}
+	}
+	return pod.Spec
+}
+
+// findMissingRequests возвращает наполовину заданные ресурсы контейнеров и значения для
+// отсутствующих полей: рекомендацию контейнера по его пиковому использованию.
+// Пустой namespace означает все namespace кластера.
+func (ma *MetricsAnalyzer) findMissingRequests(namespace string) ([]MissingResource, error) {
+	pods, err := ma.listPods(namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []MissingResource{}
+	for _, pod := range pods {
+		if isTerminated(pod) {
+			continue
+		}
+		missing := ma.missingResources(pod)
+		if len(missing) == 0 {
+			continue
+		}
+		// Без метрик находка остается в ответе, но без рекомендуемого значения
+		containers, err := ma.getContainerHotspots(pod.Name, pod.Namespace)
+		if err != nil {
+			log.Printf("Error getting container metrics for pod %s in namespace %s: %v", pod.Name, pod.Namespace, err)
+		}
+		for i := range missing {
+			for _, container := range containers {
+				if container.Container != missing[i].Container {
+					continue
+				}
+				missing[i].Recommended = container.RecommendCPU
+				if missing[i].Resource == string(corev1.ResourceMemory) {
+					missing[i].Recommended = container.RecommendMem
+				}
+			}
+		}
+		result = append(result, missing...)
+	}
+	return result, nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+)
+
+const (
+	NodePressureLow    = "low"
+	NodePressureNormal = "normal"
+	NodePressureHigh   = "high"
+)
+
+// Запас к пиковому использованию памяти, если уровень нагрузки узла неизвестен
+const defaultMemoryHeadroom = 1.2
+
+// NodePressure - загрузка памяти узла: использованная память узла в процентах от емкости (0..100)
+type NodePressure struct {
+	MemoryPercent float64
+	Level         string // low, normal, high или пустая строка, если данных нет
+}
+
+// getNodePressure оценивает загрузку памяти узла по метрикам node-exporter. Использованная память
+// (MemTotal - MemAvailable) включает системные процессы и kubelet, поэтому сравнивается с емкостью
+// узла (capacity) из кеша информера, как и MemTotal: с allocatable загрузка превышала бы 100%.
+func (ma *MetricsAnalyzer) getNodePressure(ctx context.Context, nodeName string, qlog *queryLog) (NodePressure, error) {
+	if nodeName == "" {
+		return NodePressure{}, nil
+	}
+	node, err := ma.nodeLister.Get(nodeName)
+	if err != nil {
+		// Узел уже удален из кластера: загрузку оценить нельзя, но рекомендация для пода остается
+		return NodePressure{}, nil
+	}
+	capacity := node.Status.Capacity.Memory().AsApproximateFloat64()
+	if capacity <= 0 {
+		return NodePressure{}, nil
+	}
+
+	selector := fmt.Sprintf(`{%s="%s"}`, ma.config.NodeMetricLabel, nodeName)
+	used, err := ma.queryValue(ctx, ma.config.NodeMemoryTotalMetric+selector+" - "+ma.config.NodeMemoryAvailableMetric+selector, qlog)
+	if err != nil {
+		return NodePressure{}, err
+	}
+	// Нулевое значение означает, что node-exporter не отдает метрики этого узла
+	// или NodeMetricLabel не совпадает с меткой, в которой лежит имя узла
+	if used <= 0 {
+		if _, warned := ma.nodesWithoutData.LoadOrStore(nodeName, struct{}{}); !warned {
+			log.Printf("Warning: no node-exporter series with %s=%q, node pressure is ignored; check NodeMetricLabel", ma.config.NodeMetricLabel, nodeName)
+		}
+		return NodePressure{}, nil
+	}
+
+	pressure := NodePressure{MemoryPercent: math.Min(used/capacity*100, 100), Level: NodePressureNormal}
+	switch {
+	case pressure.MemoryPercent >= ma.config.NodePressureHighPercent:
+		pressure.Level = NodePressureHigh
+	case pressure.MemoryPercent <= ma.config.NodePressureLowPercent:
+		pressure.Level = NodePressureLow
+	}
+	return pressure, nil
+}
+
+// memoryHeadroom возвращает запас к пиковому использованию памяти для уровня нагрузки узла
+func (ma *MetricsAnalyzer) memoryHeadroom(level string) float64 {
+	return ma.config.memoryHeadroom(level)
+}
+
+// memoryHeadroom возвращает запас из NodePressureMemoryHeadroom для уровня нагрузки узла
+func (c Config) memoryHeadroom(level string) float64 {
+	if headroom, ok := c.NodePressureMemoryHeadroom[level]; ok {
+		return headroom
+	}
+	return defaultMemoryHeadroom
+}
+
+// workloadMemoryHeadroom возвращает запас к пиковой памяти для пода рабочей нагрузки kind.
+// Запас из HeadroomByKind заменяет запас по загрузке узла, но на перегруженном узле берется
+// больший из двух: там нехватка памяти грозит вытеснением соседей.
+func (ma *MetricsAnalyzer) workloadMemoryHeadroom(kind, level string) float64 {
+	headroom, ok := ma.config.HeadroomByKind[kind]
+	if !ok {
+		return ma.memoryHeadroom(level)
+	}
+	if level == NodePressureHigh {
+		return math.Max(headroom, ma.memoryHeadroom(level))
+	}
+	return headroom
+}
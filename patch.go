@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"sigs.k8s.io/yaml"
+)
+
+// Content-Type ответов с патчами в YAML
+const yamlContentType = "application/yaml; charset=utf-8"
+
+// Рабочие нагрузки, шаблон пода которых лежит в spec.template и которые можно патчить.
+// Ресурсы пода без владельца не меняются без пересоздания, а шаблон Job неизменяем.
+var patchableKinds = map[string]string{
+	"Deployment":  "apps/v1",
+	"StatefulSet": "apps/v1",
+	"DaemonSet":   "apps/v1",
+	"ReplicaSet":  "apps/v1",
+}
+
+// yamlFormatFromRequest сообщает, что рекомендации запрошены патчем в YAML (?format=yaml)
+func yamlFormatFromRequest(r *http.Request) (bool, error) {
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "json":
+		return false, nil
+	case "yaml":
+		return true, nil
+	default:
+		return false, fmt.Errorf("format must be json or yaml, got %q", format)
+	}
+}
+
+// resourcePatch - рекомендация для шаблона пода рабочей нагрузки: CPU в ядрах, память в байтах
+type resourcePatch struct {
+	Kind       string
+	Name       string
+	Namespace  string
+	Spec       corev1.PodSpec // Спецификация одного из подов нагрузки, по ней делится рекомендация
+	CPURequest float64
+	CPULimit   float64
+	Memory     float64
+	SkipReason string // Непустая причина - патч не строится
+}
+
+// writeResourcePatches пишет рекомендации как strategic merge patch в YAML, по документу на
+// рабочую нагрузку. Документ годится и для kubectl patch --patch-file, и как патч в overlay Kustomize.
+// Рекомендация считается на под целиком, поэтому между анализируемыми контейнерами она делится
+// пропорционально их текущим ресурсам. Ресурсы не из RecommendResources в патч не попадают.
+func (ma *MetricsAnalyzer) writeResourcePatches(w io.Writer, patches []resourcePatch) error {
+	for _, patch := range patches {
+		fmt.Fprintf(w, "---\n# %s %s/%s\n", patch.Kind, patch.Namespace, patch.Name)
+		if patch.SkipReason != "" {
+			fmt.Fprintf(w, "# skipped: %s\n", patch.SkipReason)
+			continue
+		}
+		apiVersion, ok := patchableKinds[patch.Kind]
+		if !ok {
+			fmt.Fprintf(w, "# skipped: %s resources cannot be patched through a pod template\n", patch.Kind)
+			continue
+		}
+
+		document := map[string]interface{}{
+			"apiVersion": apiVersion,
+			"kind":       patch.Kind,
+			"metadata":   map[string]interface{}{"name": patch.Name, "namespace": patch.Namespace},
+			"spec": map[string]interface{}{
+				"template": map[string]interface{}{
+					"spec": map[string]interface{}{"containers": ma.containerPatches(patch)},
+				},
+			},
+		}
+		out, err := yaml.Marshal(document)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// containerPatches делит рекомендацию пода между анализируемыми контейнерами
+func (ma *MetricsAnalyzer) containerPatches(patch resourcePatch) []map[string]interface{} {
+	var containers []corev1.Container
+	var totalCPU, totalMemory float64
+	for _, container := range patch.Spec.Containers {
+		if ma.isContainerExcluded(container.Name) {
+			continue
+		}
+		cpu, memory := containerResources(container)
+		totalCPU += cpu
+		totalMemory += memory
+		containers = append(containers, container)
+	}
+
+	result := make([]map[string]interface{}, 0, len(containers))
+	for _, container := range containers {
+		cpu, memory := containerResources(container)
+		cpuShare, memoryShare := share(cpu, totalCPU, len(containers)), share(memory, totalMemory, len(containers))
+
+		requests, limits := map[string]string{}, map[string]string{}
+		if ma.recommendsResource(corev1.ResourceCPU) {
+			requests["cpu"] = cpuQuantity(patch.CPURequest * cpuShare)
+			limits["cpu"] = cpuQuantity(patch.CPULimit * cpuShare)
+		}
+		if ma.recommendsResource(corev1.ResourceMemory) {
+			requests["memory"] = memoryQuantity(patch.Memory * memoryShare)
+			limits["memory"] = requests["memory"]
+		}
+		result = append(result, map[string]interface{}{
+			"name":      container.Name,
+			"resources": map[string]interface{}{"requests": requests, "limits": limits},
+		})
+	}
+	return result
+}
+
+// share - доля контейнера в ресурсе пода, без текущих значений ресурс делится поровну
+func share(value, total float64, containers int) float64 {
+	if total <= 0 {
+		return 1 / float64(containers)
+	}
+	return value / total
+}
+
+// cpuQuantity округляет ядра вверх до милликор
+func cpuQuantity(cores float64) string {
+	return resource.NewMilliQuantity(int64(math.Ceil(cores*1000)), resource.DecimalSI).String()
+}
+
+// memoryQuantity округляет байты вверх до мебибайт
+func memoryQuantity(bytes float64) string {
+	return resource.NewQuantity(int64(math.Ceil(bytes/(1024*1024)))*1024*1024, resource.BinarySI).String()
+}
+
+// workloadPatches строит патчи по рекомендациям для рабочих нагрузок. У нагрузки нет отдельной
+// рекомендации запроса CPU, поэтому запрос CPU равен лимиту.
+func (ma *MetricsAnalyzer) workloadPatches(workloads []WorkloadRecommendation) []resourcePatch {
+	patches := make([]resourcePatch, 0, len(workloads))
+	for _, workload := range workloads {
+		if len(workload.Pods) == 0 {
+			continue
+		}
+		pod, err := ma.getPod(workload.Namespace, workload.Pods[0])
+		if err != nil {
+			continue
+		}
+		patches = append(patches, resourcePatch{
+			Kind:       workload.Kind,
+			Name:       workload.Name,
+			Namespace:  workload.Namespace,
+			Spec:       pod.Spec,
+			CPURequest: workload.RecommendCPU,
+			CPULimit:   workload.RecommendCPU,
+			Memory:     workload.RecommendMem,
+		})
+	}
+	return patches
+}
+
+// podPatch строит патч шаблона рабочей нагрузки по рекомендации для одного ее пода
+func (ma *MetricsAnalyzer) podPatch(metrics PodMetrics) (resourcePatch, error) {
+	pod, err := ma.getPod(metrics.Namespace, metrics.PodName)
+	if err != nil {
+		return resourcePatch{}, err
+	}
+	kind, name := ma.resolveOwner(pod)
+	patch := resourcePatch{
+		Kind:       kind,
+		Name:       name,
+		Namespace:  metrics.Namespace,
+		Spec:       pod.Spec,
+		CPURequest: metrics.RecommendCPURequest,
+		CPULimit:   metrics.RecommendCPULimit,
+		Memory:     metrics.RecommendMem,
+	}
+	if ma.skipsOptimization(pod) {
+		patch.SkipReason = fmt.Sprintf("%s=true opts the workload out of optimization", SkipOptimizationAnnotation)
+	}
+	return patch, nil
+}
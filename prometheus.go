@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+	"golang.org/x/time/rate"
+)
+
+// newPrometheusAPI создает клиент Prometheus по адресу PrometheusURL. Ограничение частоты
+// запросов действует на клиента целиком, поэтому параллельные сканирования кластеров с общим
+// Prometheus делят один лимит и вместе не перегружают его.
+func newPrometheusAPI(config Config) (v1.API, error) {
+	if config.FakeData != nil {
+		return newFakePrometheusAPI(config), nil
+	}
+
+	roundTripper := newPrometheusTransport(config)
+	if len(config.PrometheusQueryParams) > 0 {
+		roundTripper = &queryParamsRoundTripper{next: roundTripper, params: config.PrometheusQueryParams}
+	}
+
+	client, err := api.NewClient(api.Config{
+		Address:      config.PrometheusURL,
+		RoundTripper: roundTripper,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	promAPI := v1.NewAPI(client)
+	if config.PrometheusQPS <= 0 {
+		return promAPI, nil
+	}
+
+	burst := config.PrometheusBurst
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimitedAPI{
+		API:     promAPI,
+		limiter: rate.NewLimiter(rate.Limit(config.PrometheusQPS), burst),
+		jitter:  config.PrometheusQueryJitter,
+	}, nil
+}
+
+// rateLimitedAPI ограничивает частоту запросов, которые использует анализатор, и добавляет
+// к каждому случайную задержку, чтобы запросы параллельного сканирования не уходили пачками
+type rateLimitedAPI struct {
+	v1.API
+	limiter *rate.Limiter
+	jitter  time.Duration
+}
+
+func (rl *rateLimitedAPI) wait(ctx context.Context) error {
+	if rl.jitter > 0 {
+		timer := time.NewTimer(time.Duration(rand.Int63n(int64(rl.jitter))))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+	return rl.limiter.Wait(ctx)
+}
+
+func (rl *rateLimitedAPI) Query(ctx context.Context, query string, ts time.Time, opts ...v1.Option) (model.Value, v1.Warnings, error) {
+	if err := rl.wait(ctx); err != nil {
+		return nil, nil, err
+	}
+	return rl.API.Query(ctx, query, ts, opts...)
+}
+
+func (rl *rateLimitedAPI) QueryRange(ctx context.Context, query string, r v1.Range, opts ...v1.Option) (model.Value, v1.Warnings, error) {
+	if err := rl.wait(ctx); err != nil {
+		return nil, nil, err
+	}
+	return rl.API.QueryRange(ctx, query, r, opts...)
+}
+
+// queryParamsRoundTripper добавляет к каждому запросу дополнительные параметры, например
+// partial_response, dedup и max_source_resolution для Thanos Query. Параметры передаются в URL,
+// который Thanos и Cortex читают наравне с телом POST-запроса.
+type queryParamsRoundTripper struct {
+	next   http.RoundTripper
+	params map[string]string
+}
+
+func (qp *queryParamsRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	query := req.URL.Query()
+	for name, value := range qp.params {
+		query.Set(name, value)
+	}
+	req.URL.RawQuery = query.Encode()
+	return qp.next.RoundTrip(req)
+}
+
+// QueryDebug - запрос PromQL и его необработанный результат, чтобы проверить цифры анализатора
+// в Prometheus или Grafana
+type QueryDebug struct {
+	Query  string      `json:"query"`
+	Result model.Value `json:"result"`
+}
+
+// queryLog собирает предупреждения Prometheus (неполные данные, устаревшие функции и т.п.),
+// чтобы вернуть их в ответе вместе с результатом, который они могли исказить, а в режиме
+// отладки - и сами выполненные запросы с результатами.
+// nil-получатель только логирует предупреждения.
+type queryLog struct {
+	warnings []string
+	debug    bool
+	queries  []QueryDebug
+}
+
+func (ql *queryLog) add(query string, result model.Value, warnings v1.Warnings) {
+	logQueryWarnings(query, warnings)
+	if ql == nil {
+		return
+	}
+	ql.warnings = appendUnique(ql.warnings, warnings...)
+	if ql.debug {
+		ql.queries = append(ql.queries, QueryDebug{Query: query, Result: result})
+	}
+}
+
+// addMessage добавляет собственное предупреждение анализатора, не связанное с конкретным запросом
+func (ql *queryLog) addMessage(message string) {
+	if ql != nil {
+		ql.warnings = appendUnique(ql.warnings, message)
+	}
+}
+
+func logQueryWarnings(query string, warnings v1.Warnings) {
+	for _, warning := range warnings {
+		log.Printf("Warning from Prometheus for query %s: %s", query, warning)
+	}
+}
+
+// appendUnique добавляет в список значения, которых в нем еще нет
+func appendUnique(list []string, values ...string) []string {
+	for _, value := range values {
+		if !slices.Contains(list, value) {
+			list = append(list, value)
+		}
+	}
+	return list
+}
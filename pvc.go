@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/prometheus/common/model"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+type PVCUsage struct {
+	Name               string  `json:"name"`
+	Namespace          string  `json:"namespace"`
+	StorageClass       string  `json:"storage_class,omitempty"`
+	CapacityBytes      float64 `json:"capacity_bytes"`
+	UsedBytes          float64 `json:"used_bytes"`
+	UtilizationPercent float64 `json:"utilization_percent"`
+	Cost               float64 `json:"cost"`           // Стоимость всего выделенного объема
+	WastedCost         float64 `json:"wasted_cost"`    // Стоимость неиспользуемого объема
+	HasUsageData       bool    `json:"has_usage_data"` // kubelet отдает статистику только для смонтированных томов
+	OverProvisioned    bool    `json:"over_provisioned"`
+	Currency           string  `json:"currency"`
+	// Предупреждения Prometheus по запросам заполненности. Запросы общие для всех PVC,
+	// поэтому предупреждения одинаковы у всех элементов ответа.
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// getPVCUsage сопоставляет PVC с их заполненностью по метрикам kubelet.
+// Пустой namespace означает все namespace кластера.
+func (ma *MetricsAnalyzer) getPVCUsage(namespace string) ([]PVCUsage, error) {
+	var claims []*corev1.PersistentVolumeClaim
+	var err error
+	if namespace == "" {
+		claims, err = ma.pvcLister.List(labels.Everything())
+	} else {
+		claims, err = ma.pvcLister.PersistentVolumeClaims(namespace).List(labels.Everything())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	selector := ""
+	if namespace != "" {
+		selector = `{namespace="` + namespace + `"}`
+	}
+	used, usedWarnings, err := ma.queryByPVC(`sum by (namespace, persistentvolumeclaim) (` + ma.config.VolumeUsedMetric + selector + `)`)
+	if err != nil {
+		return nil, err
+	}
+	capacity, capacityWarnings, err := ma.queryByPVC(`sum by (namespace, persistentvolumeclaim) (` + ma.config.VolumeCapacityMetric + selector + `)`)
+	if err != nil {
+		return nil, err
+	}
+	warnings := appendUnique(usedWarnings, capacityWarnings...)
+
+	result := []PVCUsage{}
+	for _, claim := range claims {
+		key := claim.Namespace + "/" + claim.Name
+		usage := PVCUsage{
+			Name:      claim.Name,
+			Namespace: claim.Namespace,
+			Currency:  ma.config.Currency,
+			Warnings:  warnings,
+		}
+		if claim.Spec.StorageClassName != nil {
+			usage.StorageClass = *claim.Spec.StorageClassName
+		}
+
+		// Емкость берем из метрик, а если том не смонтирован - из статуса или запроса PVC
+		if value, ok := capacity[key]; ok {
+			usage.CapacityBytes = value
+		} else if quantity, ok := claim.Status.Capacity[corev1.ResourceStorage]; ok {
+			usage.CapacityBytes = float64(quantity.Value())
+		} else {
+			usage.CapacityBytes = float64(claim.Spec.Resources.Requests.Storage().Value())
+		}
+
+		capacityGB := usage.CapacityBytes / (1024 * 1024 * 1024)
+		usage.Cost = capacityGB * ma.config.StorageCostPerGB
+
+		if value, ok := used[key]; ok {
+			usage.HasUsageData = true
+			usage.UsedBytes = value
+			if usage.CapacityBytes > 0 {
+				usage.UtilizationPercent = value / usage.CapacityBytes * 100
+				wastedGB := (usage.CapacityBytes - value) / (1024 * 1024 * 1024)
+				usage.WastedCost = wastedGB * ma.config.StorageCostPerGB
+			}
+			usage.OverProvisioned = usage.UtilizationPercent < ma.config.PVCUnderutilizedPercent
+			// Без рекомендаций по хранилищу PVC показываются только со стоимостью и заполненностью
+			if !ma.recommendsResource(corev1.ResourceStorage) {
+				usage.OverProvisioned = false
+				usage.WastedCost = 0
+			}
+		}
+
+		result = append(result, usage)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].WastedCost > result[j].WastedCost
+	})
+	return result, nil
+}
+
+// queryByPVC выполняет запрос и возвращает значения по ключу namespace/persistentvolumeclaim
+// и предупреждения Prometheus
+func (ma *MetricsAnalyzer) queryByPVC(query string) (map[string]float64, []string, error) {
+	result, warnings, err := ma.promClient.Query(context.Background(), query, time.Now())
+	if err != nil {
+		return nil, nil, fmt.Errorf("error querying PVC stats: %w", err)
+	}
+	logQueryWarnings(query, warnings)
+
+	values := make(map[string]float64)
+	if vector, ok := result.(model.Vector); ok {
+		for _, sample := range vector {
+			key := string(sample.Metric["namespace"]) + "/" + string(sample.Metric["persistentvolumeclaim"])
+			values[key] = float64(sample.Value)
+		}
+	}
+	return values, warnings, nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// warningPrometheus отвечает на любой запрос пустым результатом с предупреждением
+type warningPrometheus struct {
+	v1.API
+	warning string
+}
+
+func (wp *warningPrometheus) Query(ctx context.Context, query string, ts time.Time, opts ...v1.Option) (model.Value, v1.Warnings, error) {
+	return model.Vector{}, v1.Warnings{wp.warning}, nil
+}
+
+func TestPVCUsageReturnsPrometheusWarnings(t *testing.T) {
+	claim := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: "data", Namespace: "default"},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			Resources: corev1.VolumeResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("10Gi")}},
+		},
+	}
+	const warning = "PromQL info: partial response"
+	analyzer := newTestAnalyzer(t, testConfig(), &warningPrometheus{warning: warning}, claim)
+
+	usage, err := analyzer.getPVCUsage("default")
+	if err != nil {
+		t.Fatalf("getPVCUsage: %v", err)
+	}
+	if len(usage) != 1 {
+		t.Fatalf("got %d PVCs, want 1", len(usage))
+	}
+	if want := []string{warning}; !reflect.DeepEqual(usage[0].Warnings, want) {
+		t.Errorf("Warnings = %q, want %q", usage[0].Warnings, want)
+	}
+}
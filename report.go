@@ -0,0 +1,166 @@
+package main
+
+import (
+	"html/template"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Количество самых избыточных подов в отчете по умолчанию
+const defaultReportTopPods = 10
+
+type reportPod struct {
+	Namespace      string
+	PodName        string
+	Priority       string
+	CurrentCPU     float64 // Ядра
+	RecommendCPU   float64
+	CurrentMemMB   float64
+	RecommendMemMB float64
+	Savings        float64
+}
+
+type reportNamespace struct {
+	Namespace string
+	Pods      int
+	Savings   float64
+}
+
+type reportData struct {
+	GeneratedAt string
+	Currency    string
+	Stats       ClusterStats
+	PeakCPU     float64 // Ядра, в Stats.TotalMaxCPU - проценты ядра
+	TopPods     []reportPod
+	Namespaces  []reportNamespace
+}
+
+// reportTemplate - самодостаточная HTML-страница со встроенными стилями: ее можно отправить
+// письмом или распечатать в PDF из браузера без фронтенда
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"money":   func(v float64) string { return formatFloat(v, 0) },
+	"cores":   func(v float64) string { return formatFloat(v, 2) },
+	"percent": func(v float64) string { return formatFloat(v, 1) },
+	"gb":      func(bytes float64) string { return formatFloat(bytes/(1024*1024*1024), 1) },
+	"mb":      func(v float64) string { return formatFloat(v, 0) },
+}).Parse(`<!DOCTYPE html>
+<html lang="ru">
+<head>
+<meta charset="utf-8">
+<title>Отчет об эффективности кластера</title>
+</head>
+<body style="font-family: Arial, Helvetica, sans-serif; color: #222; max-width: 960px; margin: 24px auto;">
+<h1 style="font-size: 22px;">Отчет об эффективности кластера</h1>
+<p style="color: #666;">Сформирован {{.GeneratedAt}}{{if .Stats.Partial}}. Сканирование прервано по таймауту: не обработано подов - {{.Stats.UnprocessedPods}}{{end}}</p>
+
+<h2 style="font-size: 18px;">Итоги</h2>
+<table style="border-collapse: collapse;">
+<tr><td style="padding: 4px 16px 4px 0;">Подов проанализировано</td><td><b>{{.Stats.TotalPods}}</b></td></tr>
+<tr><td style="padding: 4px 16px 4px 0;">CPU: выделено / пик / рекомендовано, ядер</td><td><b>{{cores .Stats.TotalCurrentCPU}} / {{cores .PeakCPU}} / {{cores .Stats.TotalRecommendCPU}}</b></td></tr>
+<tr><td style="padding: 4px 16px 4px 0;">Память: выделено / пик / рекомендовано, ГБ</td><td><b>{{gb .Stats.TotalCurrentMemory}} / {{gb .Stats.TotalMaxMemory}} / {{gb .Stats.TotalRecommendMem}}</b></td></tr>
+<tr><td style="padding: 4px 16px 4px 0;">Пиковое использование CPU / памяти от запросов</td><td><b>{{percent .Stats.CPUUtilizationPercent}}% / {{percent .Stats.MemoryUtilizationPercent}}%</b></td></tr>
+<tr><td style="padding: 4px 16px 4px 0;">Потенциальная экономия</td><td><b style="color: #1a7f37;">{{money .Stats.PotentialSavings}} {{.Currency}}</b> (CPU {{money .Stats.CPUSavings}}, память {{money .Stats.MemorySavings}})</td></tr>
+<tr><td style="padding: 4px 16px 4px 0;">С учетом накладных расходов кластера</td><td><b>{{money .Stats.EffectiveSavings}} {{.Currency}}</b></td></tr>
+</table>
+
+<h2 style="font-size: 18px;">Самые избыточные поды</h2>
+{{if .TopPods}}
+<table style="border-collapse: collapse; width: 100%; font-size: 14px;">
+<tr style="background: #f0f0f0; text-align: left;">
+<th style="padding: 6px; border: 1px solid #ddd;">Namespace</th>
+<th style="padding: 6px; border: 1px solid #ddd;">Под</th>
+<th style="padding: 6px; border: 1px solid #ddd;">Приоритет</th>
+<th style="padding: 6px; border: 1px solid #ddd;">CPU, ядер</th>
+<th style="padding: 6px; border: 1px solid #ddd;">Память, МБ</th>
+<th style="padding: 6px; border: 1px solid #ddd;">Экономия, {{.Currency}}</th>
+</tr>
+{{range .TopPods}}<tr>
+<td style="padding: 6px; border: 1px solid #ddd;">{{.Namespace}}</td>
+<td style="padding: 6px; border: 1px solid #ddd;">{{.PodName}}</td>
+<td style="padding: 6px; border: 1px solid #ddd;">{{.Priority}}</td>
+<td style="padding: 6px; border: 1px solid #ddd;">{{cores .CurrentCPU}} &rarr; {{cores .RecommendCPU}}</td>
+<td style="padding: 6px; border: 1px solid #ddd;">{{mb .CurrentMemMB}} &rarr; {{mb .RecommendMemMB}}</td>
+<td style="padding: 6px; border: 1px solid #ddd;">{{money .Savings}}</td>
+</tr>
+{{end}}</table>
+{{else}}<p>Избыточных подов не найдено.</p>{{end}}
+
+<h2 style="font-size: 18px;">Экономия по namespace</h2>
+{{if .Namespaces}}
+<table style="border-collapse: collapse; font-size: 14px;">
+<tr style="background: #f0f0f0; text-align: left;">
+<th style="padding: 6px; border: 1px solid #ddd;">Namespace</th>
+<th style="padding: 6px; border: 1px solid #ddd;">Подов</th>
+<th style="padding: 6px; border: 1px solid #ddd;">Экономия, {{.Currency}}</th>
+</tr>
+{{range .Namespaces}}<tr>
+<td style="padding: 6px; border: 1px solid #ddd;">{{.Namespace}}</td>
+<td style="padding: 6px; border: 1px solid #ddd;">{{.Pods}}</td>
+<td style="padding: 6px; border: 1px solid #ddd;">{{money .Savings}}</td>
+</tr>
+{{end}}</table>
+{{else}}<p>Нет данных.</p>{{end}}
+</body>
+</html>
+`))
+
+func formatFloat(v float64, precision int) string {
+	return strconv.FormatFloat(v, 'f', precision, 64)
+}
+
+// writeHTMLReport пишет сводку по кластеру: итоги, topPods самых избыточных подов по экономии
+// и экономию по namespace
+func (ma *MetricsAnalyzer) writeHTMLReport(w io.Writer, stats ClusterStats, topPods int) error {
+	data := reportData{
+		GeneratedAt: time.Now().Format("02.01.2006 15:04"),
+		Currency:    ma.currencyLabel(),
+		Stats:       stats,
+		PeakCPU:     stats.TotalMaxCPU / 100,
+	}
+
+	byNamespace := make(map[string]*reportNamespace)
+	for _, pod := range stats.Pods {
+		savings := ma.podSavings(pod)
+		ns, ok := byNamespace[pod.Namespace]
+		if !ok {
+			ns = &reportNamespace{Namespace: pod.Namespace}
+			byNamespace[pod.Namespace] = ns
+		}
+		ns.Pods++
+		ns.Savings += savings
+
+		if savings > 0 {
+			data.TopPods = append(data.TopPods, reportPod{
+				Namespace:      pod.Namespace,
+				PodName:        pod.PodName,
+				Priority:       pod.Priority,
+				CurrentCPU:     pod.CurrentCPU,
+				RecommendCPU:   pod.RecommendCPU,
+				CurrentMemMB:   pod.CurrentMemory / (1024 * 1024),
+				RecommendMemMB: pod.RecommendMem / (1024 * 1024),
+				Savings:        savings,
+			})
+		}
+	}
+
+	sort.Slice(data.TopPods, func(i, j int) bool {
+		return data.TopPods[i].Savings > data.TopPods[j].Savings
+	})
+	if len(data.TopPods) > topPods {
+		data.TopPods = data.TopPods[:topPods]
+	}
+
+	for _, ns := range byNamespace {
+		data.Namespaces = append(data.Namespaces, *ns)
+	}
+	sort.Slice(data.Namespaces, func(i, j int) bool {
+		if data.Namespaces[i].Savings != data.Namespaces[j].Savings {
+			return data.Namespaces[i].Savings > data.Namespaces[j].Savings
+		}
+		return data.Namespaces[i].Namespace < data.Namespaces[j].Namespace
+	})
+
+	return reportTemplate.Execute(w, data)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+// Как часто перечитывается срок хранения данных Prometheus
+const retentionRefreshInterval = time.Hour
+
+// retentionCache хранит срок хранения данных Prometheus, чтобы не запрашивать его для каждого пода.
+// Неудачная попытка тоже запоминается: Thanos и часть совместимых хранилищ не отдают runtimeinfo.
+type retentionCache struct {
+	mu        sync.Mutex
+	retention time.Duration
+	fetched   time.Time
+}
+
+// prometheusRetention возвращает срок хранения данных Prometheus: PrometheusRetention из конфигурации
+// или storage.tsdb.retention.time из /api/v1/status/runtimeinfo. 0 - срок неизвестен.
+func (ma *MetricsAnalyzer) prometheusRetention() time.Duration {
+	if ma.config.PrometheusRetention > 0 {
+		return ma.config.PrometheusRetention
+	}
+
+	cache := &ma.retentionCache
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if !cache.fetched.IsZero() && time.Since(cache.fetched) < retentionRefreshInterval {
+		return cache.retention
+	}
+
+	cache.fetched = time.Now()
+	info, err := ma.promClient.Runtimeinfo(context.Background())
+	if err != nil {
+		log.Printf("Could not get Prometheus retention, analysis windows are not clamped: %v", err)
+		cache.retention = 0
+		return 0
+	}
+	cache.retention = parseRetention(info.StorageRetention)
+	if cache.retention > 0 {
+		log.Printf("Prometheus retention: %s", model.Duration(cache.retention))
+	}
+	return cache.retention
+}
+
+// parseRetention разбирает срок хранения из runtimeinfo: "15d", "15d or 10GiB" или только размер "10GiB"
+func parseRetention(value string) time.Duration {
+	for _, part := range strings.Split(value, " or ") {
+		if duration, err := model.ParseDuration(strings.TrimSpace(part)); err == nil {
+			return time.Duration(duration)
+		}
+	}
+	return 0
+}
+
+// clampWindow ограничивает окно анализа сроком хранения данных Prometheus и предупреждает об этом:
+// запрос за окно длиннее срока хранения молча вернул бы данные только за доступную часть окна
+func (ma *MetricsAnalyzer) clampWindow(window time.Duration, qlog *queryLog) time.Duration {
+	retention := ma.prometheusRetention()
+	if retention <= 0 || window <= retention {
+		return window
+	}
+	qlog.addMessage(fmt.Sprintf("analysis window %s exceeds Prometheus retention %s, using %s",
+		model.Duration(window), model.Duration(retention), model.Duration(retention)))
+	return retention
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// ErrorSummary - количество подов, которые не удалось проанализировать по одной причине,
+// и пример ошибки для диагностики
+type ErrorSummary struct {
+	Reason  string  `json:"reason"`
+	Count   int     `json:"count"`
+	Example string  `json:"example"`
+	Pod     *PodRef `json:"pod,omitempty"` // Под, на котором получен пример
+}
+
+// scanErrors группирует ошибки анализа подов за одно сканирование по причинам
+type scanErrors struct {
+	byReason map[string]*ErrorSummary
+	total    int
+}
+
+func newScanErrors() *scanErrors {
+	return &scanErrors{byReason: make(map[string]*ErrorSummary)}
+}
+
+// add учитывает ошибку пода и сообщает, впервые ли встречена ее причина
+func (se *scanErrors) add(ref PodRef, err error) bool {
+	se.total++
+	reason := errorReason(err)
+	if summary, ok := se.byReason[reason]; ok {
+		summary.Count++
+		return false
+	}
+	se.byReason[reason] = &ErrorSummary{Reason: reason, Count: 1, Example: err.Error(), Pod: &ref}
+	return true
+}
+
+// summary возвращает количество подов с ошибками и причины по убыванию частоты
+func (se *scanErrors) summary() (int, []ErrorSummary) {
+	summaries := make([]ErrorSummary, 0, len(se.byReason))
+	for _, summary := range se.byReason {
+		summaries = append(summaries, *summary)
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		if summaries[i].Count != summaries[j].Count {
+			return summaries[i].Count > summaries[j].Count
+		}
+		return summaries[i].Reason < summaries[j].Reason
+	})
+	return se.total, summaries
+}
+
+// String перечисляет причины для итоговой строки лога
+func (se *scanErrors) String() string {
+	_, summaries := se.summary()
+	parts := make([]string, 0, len(summaries))
+	for _, summary := range summaries {
+		parts = append(parts, fmt.Sprintf("%s: %d", summary.Reason, summary.Count))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// errorReason относит ошибку анализа пода к категории. Тексты ошибок содержат имена подов
+// и запросы, поэтому группировать по ним нельзя.
+func errorReason(err error) string {
+	var promErr *v1.Error
+	var netErr net.Error
+	switch {
+	case apierrors.IsNotFound(err):
+		return "pod not found"
+	case apierrors.IsTooManyRequests(err):
+		return "kubernetes throttled"
+	case apierrors.IsForbidden(err) || apierrors.IsUnauthorized(err):
+		return "kubernetes access denied"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.As(err, &promErr):
+		return "prometheus " + string(promErr.Type)
+	case errors.As(err, &netErr):
+		return "prometheus unreachable"
+	case apierrors.ReasonForError(err) != "":
+		return "kubernetes " + strings.ToLower(string(apierrors.ReasonForError(err)))
+	}
+	return "other"
+}
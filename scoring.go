@@ -0,0 +1,216 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ScoreWeights - веса CPU и памяти в optimization_score
+type ScoreWeights struct {
+	CPU    float64
+	Memory float64
+}
+
+// weightedScore сводит составляющие score по CPU и памяти с заданными весами.
+// Нулевые веса дают простое среднее.
+func (ma *MetricsAnalyzer) weightedScore(cpuScore, memoryScore, cpuWeight, memoryWeight float64) float64 {
+	if cpuWeight+memoryWeight <= 0 {
+		return (cpuScore + memoryScore) / 2
+	}
+	return (cpuScore*cpuWeight + memoryScore*memoryWeight) / (cpuWeight + memoryWeight)
+}
+
+// scoreWeightsFromRequest разбирает ?cpu_weight= и ?mem_weight=. Без обоих параметров возвращает nil,
+// недостающий вес берется из конфигурации.
+func (ma *MetricsAnalyzer) scoreWeightsFromRequest(r *http.Request) (*ScoreWeights, error) {
+	query := r.URL.Query()
+	if query.Get("cpu_weight") == "" && query.Get("mem_weight") == "" {
+		return nil, nil
+	}
+
+	weights := &ScoreWeights{CPU: ma.config.CPUScoreWeight, Memory: ma.config.MemoryScoreWeight}
+	for param, target := range map[string]*float64{"cpu_weight": &weights.CPU, "mem_weight": &weights.Memory} {
+		value := query.Get(param)
+		if value == "" {
+			continue
+		}
+		weight, err := strconv.ParseFloat(value, 64)
+		if err != nil || weight < 0 {
+			return nil, fmt.Errorf("invalid %s %q", param, value)
+		}
+		*target = weight
+	}
+	return weights, nil
+}
+
+// reweight пересчитывает optimization_score и приоритет подов с другими весами и заново сортирует их.
+// Исходный список подов не меняется, так как он может быть общим с кешем.
+func (ma *MetricsAnalyzer) reweight(stats ClusterStats, weights ScoreWeights) ClusterStats {
+	pods := make([]PodMetrics, len(stats.Pods))
+	copy(pods, stats.Pods)
+	for i := range pods {
+		// Поду без запросов и лимитов score всегда обнуляется
+		if pods[i].Unbounded {
+			continue
+		}
+		pods[i].OptimizationScore = ma.weightedScore(pods[i].CPUScore, pods[i].MemoryScore, weights.CPU, weights.Memory)
+		pods[i].Priority = ma.priority(pods[i].OptimizationScore, ma.podSavings(pods[i]))
+	}
+	sort.SliceStable(pods, func(i, j int) bool {
+		return pods[i].OptimizationScore > pods[j].OptimizationScore
+	})
+	stats.Pods = pods
+	return stats
+}
+
+// Порядок подов в ответе /api/cluster-stats
+const (
+	SortByScore   = "score"
+	SortBySavings = "savings"
+)
+
+// sortByFromRequest читает параметр ?sort_by=, по умолчанию - по score
+func sortByFromRequest(r *http.Request) (string, error) {
+	switch value := r.URL.Query().Get("sort_by"); value {
+	case "", SortByScore:
+		return SortByScore, nil
+	case SortBySavings:
+		return SortBySavings, nil
+	default:
+		return "", fmt.Errorf("sort_by must be score or savings, got %q", value)
+	}
+}
+
+// sortPodsBySavings возвращает поды, отсортированные по абсолютной экономии: score - отношение,
+// и по нему крошечный простаивающий под оказывается выше крупного, на котором можно сэкономить больше.
+// Исходный список подов не меняется, так как он может быть общим с кешем.
+func sortPodsBySavings(pods []PodMetrics) []PodMetrics {
+	sorted := make([]PodMetrics, len(pods))
+	copy(sorted, pods)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].PotentialSavings > sorted[j].PotentialSavings
+	})
+	return sorted
+}
+
+// Направление рекомендации для фильтра ?direction= /api/cluster-stats
+const (
+	DirectionUnderprovisioned = "underprovisioned"
+	DirectionOverprovisioned  = "overprovisioned"
+)
+
+// directionFromRequest читает параметр ?direction=, пустая строка - без фильтра
+func directionFromRequest(r *http.Request) (string, error) {
+	switch value := r.URL.Query().Get("direction"); value {
+	case "", DirectionUnderprovisioned, DirectionOverprovisioned:
+		return value, nil
+	default:
+		return "", fmt.Errorf("direction must be underprovisioned or overprovisioned, got %q", value)
+	}
+}
+
+// isUnderprovisioned сообщает, что рекомендация больше текущего значения хотя бы одного ресурса:
+// такому поду грозит троттлинг или OOM. Ресурсы без лимита и запроса не учитываются.
+func isUnderprovisioned(pod PodMetrics) bool {
+	return (pod.CurrentCPU > 0 && pod.RecommendCPU > pod.CurrentCPU) ||
+		(pod.CurrentMemory > 0 && pod.RecommendMem > pod.CurrentMemory)
+}
+
+// isOverprovisioned сообщает, что рекомендация уменьшает хотя бы один ресурс и не увеличивает другие
+func isOverprovisioned(pod PodMetrics) bool {
+	return !isUnderprovisioned(pod) && (pod.RecommendCPU < pod.CurrentCPU || pod.RecommendMem < pod.CurrentMemory)
+}
+
+// filterPodsByDirection оставляет поды, рекомендация которых идет в направлении direction.
+// Исходный список подов не меняется, так как он может быть общим с кешем.
+func filterPodsByDirection(pods []PodMetrics, direction string) []PodMetrics {
+	matches := isUnderprovisioned
+	switch direction {
+	case "":
+		return pods
+	case DirectionOverprovisioned:
+		matches = isOverprovisioned
+	}
+	filtered := make([]PodMetrics, 0, len(pods))
+	for _, pod := range pods {
+		if matches(pod) {
+			filtered = append(filtered, pod)
+		}
+	}
+	return filtered
+}
+
+var priorityRank = map[string]int{
+	PriorityCritical: 0,
+	PriorityHigh:     1,
+	PriorityMedium:   2,
+	PriorityLow:      3,
+	PriorityOK:       4,
+}
+
+// limitPods оставляет не больше max подов с наивысшим приоритетом, при равном приоритете - идущие
+// раньше, и сохраняет их исходный порядок. max <= 0 - без ограничения.
+func limitPods(pods []PodMetrics, max int) ([]PodMetrics, bool) {
+	if max <= 0 || len(pods) <= max {
+		return pods, false
+	}
+
+	order := make([]int, len(pods))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return priorityRank[pods[order[i]].Priority] < priorityRank[pods[order[j]].Priority]
+	})
+	kept := order[:max]
+	sort.Ints(kept)
+
+	result := make([]PodMetrics, 0, max)
+	for _, i := range kept {
+		result = append(result, pods[i])
+	}
+	return result, true
+}
+
+// getReweightedClusterStats возвращает статистику кластера с другими весами score. Для полного
+// сканирования без фильтров берется результат последнего сканирования, если он моложе StatsCacheTTL,
+// и Prometheus повторно не опрашивается.
+func (ma *MetricsAnalyzer) getReweightedClusterStats(opts ScanOptions, weights ScoreWeights) (ClusterStats, error) {
+	if opts.isDefault() {
+		if stats, ok := ma.statsCache.load(ma.config.StatsCacheTTL); ok {
+			return ma.reweight(stats, weights), nil
+		}
+	}
+	stats, err := ma.getClusterStats(opts)
+	if err != nil {
+		return ClusterStats{}, err
+	}
+	return ma.reweight(stats, weights), nil
+}
+
+// statsCache хранит результат последнего полного сканирования кластера
+type statsCache struct {
+	mu      sync.Mutex
+	stats   ClusterStats
+	updated time.Time
+}
+
+func (c *statsCache) store(stats ClusterStats) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stats = stats
+	c.updated = time.Now()
+}
+
+func (c *statsCache) load(ttl time.Duration) (ClusterStats, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.updated.IsZero() || time.Since(c.updated) > ttl {
+		return ClusterStats{}, false
+	}
+	return c.stats, true
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Реплики анализатора за балансировщиком делят результат полного сканирования кластера:
+// реплика без SharedCacheURL - ведущая, она сканирует кластер и отдает последний результат
+// на /api/cluster-stats/cached. Ведомые реплики (SharedCacheURL указывает на ведущую) вместо
+// собственного полного сканирования читают этот результат, поэтому нагрузка на Prometheus
+// не растет с количеством реплик. Сканирования с фильтрами выполняются каждой репликой сама.
+
+// defaultSharedCacheTimeout ограничивает ожидание ведущей реплики, когда ScanTimeout не задан:
+// без ограничения зависшая ведущая реплика держала бы запросы ведомых бесконечно
+const defaultSharedCacheTimeout = 5 * time.Minute
+
+// isFollower сообщает, что полные сканирования берутся у ведущей реплики
+func (ma *MetricsAnalyzer) isFollower() bool {
+	return ma.config.SharedCacheURL != ""
+}
+
+// getCachedClusterStats возвращает результат последнего полного сканирования, если он моложе
+// StatsCacheTTL, и сканирует кластер иначе. Одновременные запросы ведомых реплик при пустом кеше
+// дожидаются одного сканирования, а не запускают каждый свое.
+func (ma *MetricsAnalyzer) getCachedClusterStats() (ClusterStats, error) {
+	if stats, ok := ma.statsCache.load(ma.config.StatsCacheTTL); ok {
+		return stats, nil
+	}
+
+	ma.scanMu.Lock()
+	defer ma.scanMu.Unlock()
+	if stats, ok := ma.statsCache.load(ma.config.StatsCacheTTL); ok {
+		return stats, nil
+	}
+	return ma.getClusterStats(ScanOptions{})
+}
+
+// fetchSharedClusterStats получает результат полного сканирования у ведущей реплики
+func (ma *MetricsAnalyzer) fetchSharedClusterStats() (ClusterStats, error) {
+	endpoint, err := url.JoinPath(ma.config.SharedCacheURL, "/api/cluster-stats/cached")
+	if err != nil {
+		return ClusterStats{}, fmt.Errorf("invalid shared cache URL: %w", err)
+	}
+	if ma.contextName != "" {
+		endpoint += "?cluster=" + url.QueryEscape(ma.contextName)
+	}
+
+	// Ведущая реплика может сканировать кластер, если ее кеш устарел
+	client := &http.Client{Timeout: defaultSharedCacheTimeout}
+	if ma.config.ScanTimeout > 0 {
+		client.Timeout = ma.config.ScanTimeout + 30*time.Second
+	}
+	resp, err := client.Get(endpoint)
+	if err != nil {
+		return ClusterStats{}, fmt.Errorf("error fetching shared cluster stats: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return ClusterStats{}, fmt.Errorf("error fetching shared cluster stats: leader returned status %d", resp.StatusCode)
+	}
+
+	var stats ClusterStats
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		return ClusterStats{}, fmt.Errorf("error decoding shared cluster stats: %w", err)
+	}
+	return stats, nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// streamingIncompatibleParams - параметры /api/cluster-stats, которым нужен весь список подов до ответа.
+// Потоковый режим отдает поды по мере расчета и учесть их не может.
+var streamingIncompatibleParams = []string{"sort_by", "direction", "cpu_weight", "mem_weight"}
+
+// checkStreamingParams возвращает ошибку, если вместе со streaming=true заданы параметры,
+// которые потоковый режим молча проигнорировал бы
+func checkStreamingParams(r *http.Request, opts ScanOptions) error {
+	for _, name := range streamingIncompatibleParams {
+		if r.URL.Query().Get(name) != "" {
+			return fmt.Errorf("%s is not supported with streaming=true", name)
+		}
+	}
+	if opts.CollapseReplicas {
+		return fmt.Errorf("collapse_replicas is not supported with streaming=true")
+	}
+	return nil
+}
+
+// streamClusterStats пишет статистику кластера в ответ по мере расчета: сначала массив pods
+// в порядке namespace (без глобальной сортировки), затем итоговые поля. Так весь список подов
+// не держится в памяти целиком. OnPod в opts заменяется. Уведомления об экономии подов
+// полного сканирования отправляются так же, как при обычном сканировании.
+func (ma *MetricsAnalyzer) streamClusterStats(w http.ResponseWriter, opts ScanOptions) {
+	flusher, _ := w.(http.Flusher)
+	started := false
+	first := true
+
+	start := func() {
+		if !started {
+			fmt.Fprint(w, `{"pods":[`)
+			started = true
+		}
+	}
+
+	opts.OnPod = func(metrics PodMetrics) {
+		payload, err := json.Marshal(metrics)
+		if err != nil {
+			log.Printf("Error encoding metrics for pod %s: %v", metrics.PodName, err)
+			return
+		}
+		start()
+		if !first {
+			fmt.Fprint(w, ",")
+		}
+		first = false
+		w.Write(payload)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	stats, err := ma.getClusterStats(opts)
+	if err != nil {
+		log.Printf("Error getting cluster stats: %v", err)
+		if !started {
+			http.Error(w, fmt.Sprintf("Error getting cluster stats: %v", err), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// Поды уже записаны, поэтому pods опускается, и итоговые поля дописываются в тот же объект
+	totals, err := json.Marshal(struct {
+		ClusterStats
+		Pods []PodMetrics `json:"pods,omitempty"`
+	}{ClusterStats: stats})
+	if err != nil {
+		log.Printf("Error encoding cluster stats: %v", err)
+		return
+	}
+	start()
+	fmt.Fprint(w, "],")
+	w.Write(totals[1:])
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestStreamedScanSendsPodAlerts(t *testing.T) {
+	alerts := make(chan SavingsAlert, 1)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var alert SavingsAlert
+		if err := json.NewDecoder(r.Body).Decode(&alert); err != nil {
+			t.Errorf("decoding alert: %v", err)
+		}
+		alerts <- alert
+	}))
+	defer webhook.Close()
+
+	config := testConfig()
+	config.AlertWebhookURL = webhook.URL
+	config.AlertPodSavingsThreshold = 100
+	pod := testPod("default", "web", time.Now().Add(-24*time.Hour), resources("2", "1Gi"))
+	usage := map[string][]containerUsage{"default/web": {{cpu: 10, memory: 100 * 1024 * 1024}}}
+	namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}}
+	analyzer := newTestAnalyzer(t, config, &seriesPrometheus{config: config, containers: usage}, namespace, pod)
+
+	recorder := httptest.NewRecorder()
+	analyzer.streamClusterStats(recorder, ScanOptions{})
+	var streamed ClusterStats
+	if err := json.Unmarshal(recorder.Body.Bytes(), &streamed); err != nil {
+		t.Fatalf("streamed response is not valid JSON: %v\n%s", err, recorder.Body)
+	}
+	if len(streamed.Pods) != 1 || streamed.TotalPods != 1 {
+		t.Errorf("streamed %d pods with total %d, want 1 and 1", len(streamed.Pods), streamed.TotalPods)
+	}
+
+	select {
+	case alert := <-alerts:
+		if len(alert.Pods) != 1 || alert.Pods[0].PodName != "web" {
+			t.Errorf("alert pods = %+v, want web", alert.Pods)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("streamed scan sent no savings alert")
+	}
+}
+
+func TestStreamingRejectsIncompatibleParams(t *testing.T) {
+	handler := newTestServer(t, snapshotAnalyzer(t))
+	for _, params := range []string{"sort_by=savings", "direction=overprovisioned", "cpu_weight=0.7", "mem_weight=0.3", "collapse_replicas=true"} {
+		t.Run(params, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/cluster-stats?streaming=true&"+params, nil))
+			if recorder.Code != http.StatusBadRequest {
+				t.Errorf("status = %d, want 400", recorder.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// throttleRetryTransport повторяет запросы к API Kubernetes, пока API-сервер отвечает
+// 429 (Too Many Requests), не больше retries раз. Пауза между попытками растет экспоненциально
+// и не меньше Retry-After из ответа. client-go сам повторяет ответы с Retry-After, но сдается
+// после нескольких попыток, а ответы без заголовка не повторяет вовсе. Транспорт стоит под
+// всеми клиентами анализатора, поэтому повторяются и list/watch информеров, по которым
+// сканирования читают поды, узлы и workload-ы, и прямые запросы событий и SubjectAccessReview.
+type throttleRetryTransport struct {
+	next    http.RoundTripper
+	retries int
+}
+
+// wrapThrottleRetry оборачивает транспорт клиента Kubernetes повтором ответов 429
+func wrapThrottleRetry(retries int) func(http.RoundTripper) http.RoundTripper {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return &throttleRetryTransport{next: next, retries: retries}
+	}
+}
+
+func (t *throttleRetryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	backoff := wait.Backoff{Duration: 500 * time.Millisecond, Factor: 2, Jitter: 0.2, Steps: 8, Cap: 30 * time.Second}
+	for attempt := 0; ; attempt++ {
+		resp, err := t.next.RoundTrip(req)
+		// Тело запроса, которое нельзя прочитать заново, повторно не отправить
+		replayable := req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+		if err != nil || resp.StatusCode != http.StatusTooManyRequests || attempt >= t.retries || !replayable {
+			return resp, err
+		}
+
+		delay := backoff.Step()
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+			delay = max(delay, time.Duration(seconds)*time.Second)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		log.Printf("Kubernetes API throttled %s %s, retrying in %v (attempt %d/%d)", req.Method, req.URL.Path, delay, attempt+1, t.retries)
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(delay):
+		}
+
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+	"k8s.io/client-go/rest"
+)
+
+// Параллельное сканирование держит одновременно десятки запросов к одному хосту. Стандартный
+// транспорт net/http оставляет открытыми только 2 простаивающих соединения на хост, остальные
+// закрываются после ответа, и каждое следующее сканирование заново открывает соединения,
+// тратя время на TLS и исчерпывая эфемерные порты.
+
+// newPrometheusTransport возвращает транспорт клиента Prometheus с пулом соединений из конфигурации
+func newPrometheusTransport(config Config) http.RoundTripper {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if config.PrometheusMaxIdleConnsPerHost > 0 {
+		transport.MaxIdleConnsPerHost = config.PrometheusMaxIdleConnsPerHost
+		transport.MaxIdleConns = max(transport.MaxIdleConns, config.PrometheusMaxIdleConnsPerHost)
+	}
+	if config.IdleConnTimeout > 0 {
+		transport.IdleConnTimeout = config.IdleConnTimeout
+	}
+	return transport
+}
+
+// tuneKubeConfig задает ограничение частоты запросов клиента Kubernetes, повтор ответов 429 и, если задан
+// KubernetesMaxIdleConnsPerHost, собственный транспорт с пулом соединений. client-go не дает
+// настроить пул своего транспорта, поэтому транспорт строится из TLS-настроек kubeconfig,
+// а аутентификация по токену продолжает работать поверх него.
+// Exec-плагины (EKS, GKE, OIDC) и auth-провайдеры могут выдавать клиентские сертификаты, а их
+// client-go не разрешает сочетать с собственным транспортом, поэтому для них остается транспорт client-go.
+func tuneKubeConfig(k8sConfig *rest.Config, config Config) (*rest.Config, error) {
+	k8sConfig = rest.CopyConfig(k8sConfig)
+	if config.KubernetesQPS > 0 {
+		k8sConfig.QPS = config.KubernetesQPS
+		k8sConfig.Burst = max(config.KubernetesBurst, 1)
+	}
+	if config.KubernetesThrottleRetries > 0 {
+		k8sConfig.Wrap(wrapThrottleRetry(config.KubernetesThrottleRetries))
+	}
+	if config.KubernetesMaxIdleConnsPerHost <= 0 || k8sConfig.Transport != nil {
+		return k8sConfig, nil
+	}
+	if k8sConfig.ExecProvider != nil || k8sConfig.AuthProvider != nil {
+		log.Printf("Kubeconfig uses an auth plugin, keeping the default Kubernetes connection pool")
+		return k8sConfig, nil
+	}
+
+	tlsConfig, err := rest.TLSConfigFor(k8sConfig)
+	if err != nil {
+		return nil, fmt.Errorf("error building Kubernetes TLS config: %w", err)
+	}
+	transport := &http.Transport{
+		Proxy:               k8sConfig.Proxy,
+		DialContext:         k8sConfig.Dial,
+		TLSClientConfig:     tlsConfig,
+		MaxIdleConnsPerHost: config.KubernetesMaxIdleConnsPerHost,
+		IdleConnTimeout:     config.IdleConnTimeout,
+	}
+	// Таймауты, прокси из окружения и HTTP/2 - как у транспорта client-go по умолчанию
+	k8sConfig.Transport = utilnet.SetTransportDefaults(transport)
+	// Клиентский сертификат, CA и insecure уже вошли в транспорт, а client-go отклоняет
+	// собственный транспорт вместе с TLS-настройками
+	k8sConfig.TLSClientConfig = rest.TLSClientConfig{}
+	return k8sConfig, nil
+}
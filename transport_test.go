@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+func TestTuneKubeConfigPoolsConnections(t *testing.T) {
+	k8sConfig := &rest.Config{Host: "https://kubernetes.example", BearerToken: "token"}
+	tuned, err := tuneKubeConfig(k8sConfig, Config{KubernetesMaxIdleConnsPerHost: 50})
+	if err != nil {
+		t.Fatalf("tuneKubeConfig: %v", err)
+	}
+	if tuned.Transport == nil {
+		t.Fatal("expected a pooled transport")
+	}
+	if _, err := kubernetes.NewForConfig(tuned); err != nil {
+		t.Fatalf("NewForConfig: %v", err)
+	}
+}
+
+func TestTuneKubeConfigKeepsExecAuth(t *testing.T) {
+	k8sConfig := &rest.Config{
+		Host: "https://kubernetes.example",
+		ExecProvider: &clientcmdapi.ExecConfig{
+			APIVersion:      "client.authentication.k8s.io/v1",
+			Command:         "aws",
+			Args:            []string{"eks", "get-token", "--cluster-name", "prod"},
+			InteractiveMode: clientcmdapi.NeverExecInteractiveMode,
+		},
+	}
+	tuned, err := tuneKubeConfig(k8sConfig, Config{KubernetesMaxIdleConnsPerHost: 50})
+	if err != nil {
+		t.Fatalf("tuneKubeConfig: %v", err)
+	}
+	if tuned.Transport != nil {
+		t.Fatal("exec auth must keep the client-go transport")
+	}
+	if _, err := kubernetes.NewForConfig(tuned); err != nil {
+		t.Fatalf("NewForConfig with exec auth: %v", err)
+	}
+}
+
+func TestTuneKubeConfigRetriesThrottledLists(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"kind":"PodList","apiVersion":"v1","items":[{"metadata":{"name":"web-0","namespace":"shop"}}]}`))
+	}))
+	defer server.Close()
+
+	tuned, err := tuneKubeConfig(&rest.Config{Host: server.URL}, Config{KubernetesThrottleRetries: 2})
+	if err != nil {
+		t.Fatalf("tuneKubeConfig: %v", err)
+	}
+	client, err := kubernetes.NewForConfig(tuned)
+	if err != nil {
+		t.Fatalf("NewForConfig: %v", err)
+	}
+	pods, err := client.CoreV1().Pods("").List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("List after 429: %v", err)
+	}
+	if len(pods.Items) != 1 || calls.Load() != 2 {
+		t.Fatalf("got %d pods after %d calls, want 1 pod after 2 calls", len(pods.Items), calls.Load())
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+type ValidateRequest struct {
+	Namespace     string    `json:"namespace"`
+	PodName       string    `json:"pod_name"`
+	CPUMillicores float64   `json:"cpu_millicores"`
+	MemoryBytes   float64   `json:"memory_bytes"`
+	Since         time.Time `json:"since"` // Время расчета рекомендации, по умолчанию - начало HistoryWindow
+}
+
+// ValidateResult сообщает, покрывает ли предложенный уровень ресурсов пиковое использование
+// пода с момента расчета рекомендации. CPU в ядрах, память в байтах.
+type ValidateResult struct {
+	Safe       bool     `json:"safe"`
+	PeakCPU    float64  `json:"peak_cpu"`
+	PeakMemory float64  `json:"peak_memory"`
+	Reasons    []string `json:"reasons,omitempty"`
+	Warnings   []string `json:"warnings,omitempty"`
+}
+
+// validateRecommendation перепроверяет предложенные ресурсы по свежему пиковому использованию,
+// чтобы не применить рекомендацию, которая устарела из-за всплеска нагрузки после ее расчета
+func (ma *MetricsAnalyzer) validateRecommendation(ctx context.Context, req ValidateRequest) (ValidateResult, error) {
+	if _, err := ma.getPod(req.Namespace, req.PodName); err != nil {
+		return ValidateResult{}, err
+	}
+
+	window := ma.config.HistoryWindow
+	if !req.Since.IsZero() {
+		window = time.Since(req.Since)
+	}
+	// Подзапрос короче шага не содержит ни одной точки
+	if window < ma.config.QueryStep {
+		window = ma.config.QueryStep
+	}
+	windowStr := model.Duration(window).String()
+	step := model.Duration(ma.config.QueryStep).String()
+
+	var qlog queryLog
+	peakCPU, err := ma.queryValue(ctx, fmt.Sprintf("max_over_time((%s)[%s:%s])", ma.cpuUsageExpr(req.PodName, req.Namespace), windowStr, step), &qlog)
+	if err != nil {
+		return ValidateResult{}, err
+	}
+	peakMemory, err := ma.queryValue(ctx, fmt.Sprintf("max_over_time((%s)[%s:%s])", ma.memoryUsageExpr(req.PodName, req.Namespace), windowStr, step), &qlog)
+	if err != nil {
+		return ValidateResult{}, err
+	}
+
+	result := ValidateResult{
+		Safe:       true,
+		PeakCPU:    peakCPU / 100.0, // Проценты ядра в ядра
+		PeakMemory: peakMemory,
+		Warnings:   qlog.warnings,
+	}
+	if cpu := req.CPUMillicores / 1000; cpu < result.PeakCPU {
+		result.Safe = false
+		result.Reasons = append(result.Reasons, fmt.Sprintf("proposed cpu %.3f is below peak usage %.3f", cpu, result.PeakCPU))
+	}
+	if req.MemoryBytes < result.PeakMemory {
+		result.Safe = false
+		result.Reasons = append(result.Reasons, fmt.Sprintf("proposed memory %.0f bytes is below peak usage %.0f bytes", req.MemoryBytes, result.PeakMemory))
+	}
+	return result, nil
+}
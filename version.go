@@ -0,0 +1,49 @@
+package main
+
+import (
+	"runtime"
+
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// Заполняются при сборке через -ldflags "-X main.version=... -X main.commit=... -X main.buildDate=..."
+var (
+	version   = "dev"
+	commit    = "unknown"
+	buildDate = "unknown"
+)
+
+type VersionInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildDate string `json:"build_date"`
+	GoVersion string `json:"go_version"`
+	Context   string `json:"context,omitempty"`
+	Cluster   string `json:"cluster,omitempty"`
+}
+
+func (ma *MetricsAnalyzer) getVersionInfo() VersionInfo {
+	info := VersionInfo{
+		Version:   version,
+		Commit:    commit,
+		BuildDate: buildDate,
+		GoVersion: runtime.Version(),
+	}
+
+	// Без kubeconfig анализатор работает внутри кластера и имени контекста нет
+	if ma.config.KubeconfigPath == "" {
+		return info
+	}
+	raw, err := clientcmd.LoadFromFile(ma.config.KubeconfigPath)
+	if err != nil {
+		return info
+	}
+	info.Context = raw.CurrentContext
+	if ma.contextName != "" {
+		info.Context = ma.contextName
+	}
+	if kubeContext, ok := raw.Contexts[info.Context]; ok {
+		info.Cluster = kubeContext.Cluster
+	}
+	return info
+}
@@ -0,0 +1,57 @@
+package main
+
+import "fmt"
+
+type WhatIfRequest struct {
+	Namespace     string  `json:"namespace"`
+	PodName       string  `json:"pod_name"`
+	CPUMillicores float64 `json:"cpu_millicores"`
+	MemoryBytes   float64 `json:"memory_bytes"`
+}
+
+// WhatIfResult - стоимость пода при заданных ресурсах в сравнении с текущими.
+// CPU в ядрах, память в байтах, стоимость в валюте Currency.
+type WhatIfResult struct {
+	Namespace     string  `json:"namespace"`
+	PodName       string  `json:"pod_name"`
+	CurrentCPU    float64 `json:"current_cpu"`
+	CurrentMemory float64 `json:"current_memory"`
+	CurrentCost   float64 `json:"current_cost"`
+	CPU           float64 `json:"cpu"`
+	Memory        float64 `json:"memory"`
+	Cost          float64 `json:"cost"`
+	CostDelta     float64 `json:"cost_delta"` // Отрицательное значение - экономия
+	Currency      string  `json:"currency"`
+}
+
+// resourceCost считает стоимость ресурсов по ценам из конфигурации
+func (ma *MetricsAnalyzer) resourceCost(cpuCores, memoryBytes float64) float64 {
+	return cpuCores*ma.config.CPUCostPerCore + memoryBytes/(1024*1024)*ma.config.MemoryCostPerMB
+}
+
+// whatIf считает стоимость пода при заданных ресурсах, ничего не меняя в кластере
+func (ma *MetricsAnalyzer) whatIf(req WhatIfRequest) (WhatIfResult, error) {
+	if req.CPUMillicores < 0 || req.MemoryBytes < 0 {
+		return WhatIfResult{}, fmt.Errorf("cpu_millicores and memory_bytes must not be negative")
+	}
+
+	pod, err := ma.getPod(req.Namespace, req.PodName)
+	if err != nil {
+		return WhatIfResult{}, err
+	}
+	currentCPU, currentMemory, _ := ma.podSpecResources(pod.Spec)
+
+	result := WhatIfResult{
+		Namespace:     req.Namespace,
+		PodName:       req.PodName,
+		CurrentCPU:    currentCPU,
+		CurrentMemory: currentMemory,
+		CurrentCost:   ma.resourceCost(currentCPU, currentMemory),
+		CPU:           req.CPUMillicores / 1000,
+		Memory:        req.MemoryBytes,
+		Currency:      ma.config.Currency,
+	}
+	result.Cost = ma.resourceCost(result.CPU, result.Memory)
+	result.CostDelta = result.Cost - result.CurrentCost
+	return result, nil
+}
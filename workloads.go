@@ -0,0 +1,374 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// WorkloadResources описывает ресурсы, зарезервированные шаблоном Deployment/StatefulSet,
+// независимо от того, запущены ли его поды
+type WorkloadResources struct {
+	Kind             string  `json:"kind"`
+	Name             string  `json:"name"`
+	Namespace        string  `json:"namespace"`
+	Replicas         int32   `json:"replicas"`
+	RunningPods      int     `json:"running_pods"`
+	CPUPerReplica    float64 `json:"cpu_per_replica"`
+	MemoryPerReplica float64 `json:"memory_per_replica"`
+	TotalCPU         float64 `json:"total_cpu"`
+	TotalMemory      float64 `json:"total_memory"`
+	NoRunningPods    bool    `json:"no_running_pods"` // Нет ни одного пода в фазе Running: отмасштабирован в ноль или поды не запускаются
+}
+
+// getWorkloadResources перечисляет Deployment и StatefulSet напрямую, чтобы в отчет попадали
+// и рабочие нагрузки без запущенных подов. Пустой namespace означает все namespace кластера.
+func (ma *MetricsAnalyzer) getWorkloadResources(namespace string) ([]WorkloadResources, error) {
+	result := []WorkloadResources{}
+
+	var deployments []*appsv1.Deployment
+	var err error
+	if namespace == "" {
+		deployments, err = ma.deploymentLister.List(labels.Everything())
+	} else {
+		deployments, err = ma.deploymentLister.Deployments(namespace).List(labels.Everything())
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, deployment := range deployments {
+		workload, err := ma.workloadResources("Deployment", deployment.Namespace, deployment.Name,
+			deployment.Spec.Replicas, deployment.Spec.Selector, deployment.Spec.Template.Spec)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, workload)
+	}
+
+	var statefulSets []*appsv1.StatefulSet
+	if namespace == "" {
+		statefulSets, err = ma.statefulSetLister.List(labels.Everything())
+	} else {
+		statefulSets, err = ma.statefulSetLister.StatefulSets(namespace).List(labels.Everything())
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, statefulSet := range statefulSets {
+		workload, err := ma.workloadResources("StatefulSet", statefulSet.Namespace, statefulSet.Name,
+			statefulSet.Spec.Replicas, statefulSet.Spec.Selector, statefulSet.Spec.Template.Spec)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, workload)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Namespace != result[j].Namespace {
+			return result[i].Namespace < result[j].Namespace
+		}
+		if result[i].Kind != result[j].Kind {
+			return result[i].Kind < result[j].Kind
+		}
+		return result[i].Name < result[j].Name
+	})
+	return result, nil
+}
+
+func (ma *MetricsAnalyzer) workloadResources(kind, namespace, name string, replicas *int32,
+	selector *metav1.LabelSelector, spec corev1.PodSpec) (WorkloadResources, error) {
+	// По умолчанию Kubernetes создает одну реплику
+	desired := int32(1)
+	if replicas != nil {
+		desired = *replicas
+	}
+
+	podSelector, err := metav1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		return WorkloadResources{}, err
+	}
+	pods, err := ma.podLister.Pods(namespace).List(podSelector)
+	if err != nil {
+		return WorkloadResources{}, err
+	}
+	running := 0
+	for _, pod := range pods {
+		if pod.Status.Phase == corev1.PodRunning {
+			running++
+		}
+	}
+
+	cpu, memory, _ := ma.podSpecResources(spec)
+	return WorkloadResources{
+		Kind:             kind,
+		Name:             name,
+		Namespace:        namespace,
+		Replicas:         desired,
+		RunningPods:      running,
+		CPUPerReplica:    cpu,
+		MemoryPerReplica: memory,
+		TotalCPU:         cpu * float64(desired),
+		TotalMemory:      memory * float64(desired),
+		NoRunningPods:    running == 0,
+	}, nil
+}
+
+// WorkloadRecommendation - единая рекомендация для шаблона рабочей нагрузки, рассчитанная по всем ее репликам
+type WorkloadRecommendation struct {
+	Kind              string   `json:"kind"`
+	Name              string   `json:"name"`
+	Namespace         string   `json:"namespace"`
+	ReplicaCount      int      `json:"replica_count"`
+	Pods              []string `json:"pods"`
+	CurrentCPU        float64  `json:"current_cpu"`    // На одну реплику
+	CurrentMemory     float64  `json:"current_memory"` // На одну реплику
+	MaxCPU            float64  `json:"max_cpu"`        // Перцентиль ReplicaAggregationPercentile по пиковому использованию реплик
+	MaxMemory         float64  `json:"max_memory"`     // Перцентиль ReplicaAggregationPercentile по пиковому использованию реплик
+	RecommendCPU      float64  `json:"recommend_cpu"`
+	RecommendMem      float64  `json:"recommend_memory"`
+	OptimizationScore float64  `json:"optimization_score"`
+}
+
+// resolveOwner возвращает рабочую нагрузку верхнего уровня, которой принадлежит под.
+// Для подов Deployment поднимается через ReplicaSet, под без владельца считается сам себе нагрузкой.
+func (ma *MetricsAnalyzer) resolveOwner(pod *corev1.Pod) (string, string) {
+	ref := metav1.GetControllerOf(pod)
+	if ref == nil {
+		return "Pod", pod.Name
+	}
+	if ref.Kind == "ReplicaSet" {
+		replicaSet, err := ma.replicaSetLister.ReplicaSets(pod.Namespace).Get(ref.Name)
+		if err == nil {
+			if owner := metav1.GetControllerOf(replicaSet); owner != nil && owner.Kind == "Deployment" {
+				return owner.Kind, owner.Name
+			}
+		}
+	}
+	return ref.Kind, ref.Name
+}
+
+// SkipOptimizationAnnotation со значением "true" на поде или его рабочей нагрузке исключает под
+// из рекомендаций, например когда запас ресурсов оставлен намеренно под переключение при сбое
+const SkipOptimizationAnnotation = "magnittech.io/skip-optimization"
+
+// Нижние границы рекомендаций в единицах Kubernetes, например "500m" и "256Mi"
+const (
+	MinCPUAnnotation    = "magnittech.io/min-cpu"
+	MinMemoryAnnotation = "magnittech.io/min-memory"
+)
+
+// skipsOptimization проверяет аннотацию отказа от оптимизации на поде и его рабочей нагрузке
+func (ma *MetricsAnalyzer) skipsOptimization(pod *corev1.Pod) bool {
+	value, _ := ma.workloadAnnotation(pod, SkipOptimizationAnnotation)
+	return value == "true"
+}
+
+// resourceFloors читает нижние границы рекомендаций из аннотаций пода или его рабочей нагрузки.
+// CPU в ядрах, память в байтах, отсутствующая аннотация - без ограничения.
+func (ma *MetricsAnalyzer) resourceFloors(pod *corev1.Pod) (ResourceSize, error) {
+	var floors ResourceSize
+	if value, ok := ma.workloadAnnotation(pod, MinCPUAnnotation); ok {
+		quantity, err := resource.ParseQuantity(value)
+		if err != nil {
+			return ResourceSize{}, fmt.Errorf("invalid %s annotation: %w", MinCPUAnnotation, err)
+		}
+		floors.CPU = quantity.AsApproximateFloat64()
+	}
+	if value, ok := ma.workloadAnnotation(pod, MinMemoryAnnotation); ok {
+		quantity, err := resource.ParseQuantity(value)
+		if err != nil {
+			return ResourceSize{}, fmt.Errorf("invalid %s annotation: %w", MinMemoryAnnotation, err)
+		}
+		floors.Memory = quantity.AsApproximateFloat64()
+	}
+	return floors, nil
+}
+
+// workloadAnnotation ищет аннотацию на поде, затем по цепочке его владельцев
+// (ReplicaSet -> Deployment, StatefulSet). Ближайшее к поду значение имеет приоритет.
+func (ma *MetricsAnalyzer) workloadAnnotation(pod *corev1.Pod, key string) (string, bool) {
+	if value, ok := pod.Annotations[key]; ok {
+		return value, true
+	}
+	ref := metav1.GetControllerOf(pod)
+	if ref == nil {
+		return "", false
+	}
+	switch ref.Kind {
+	case "ReplicaSet":
+		replicaSet, err := ma.replicaSetLister.ReplicaSets(pod.Namespace).Get(ref.Name)
+		if err != nil {
+			return "", false
+		}
+		if value, ok := replicaSet.Annotations[key]; ok {
+			return value, true
+		}
+		owner := metav1.GetControllerOf(replicaSet)
+		if owner == nil || owner.Kind != "Deployment" {
+			return "", false
+		}
+		deployment, err := ma.deploymentLister.Deployments(pod.Namespace).Get(owner.Name)
+		if err != nil {
+			return "", false
+		}
+		value, ok := deployment.Annotations[key]
+		return value, ok
+	case "StatefulSet":
+		statefulSet, err := ma.statefulSetLister.StatefulSets(pod.Namespace).Get(ref.Name)
+		if err != nil {
+			return "", false
+		}
+		value, ok := statefulSet.Annotations[key]
+		return value, ok
+	}
+	return "", false
+}
+
+// getWorkloadRecommendations анализирует поды namespace (пустая строка - все namespace)
+// и сводит реплики каждой рабочей нагрузки в одну рекомендацию. Поды с аннотацией отказа
+// от оптимизации не анализируются, как и при сканировании кластера.
+func (ma *MetricsAnalyzer) getWorkloadRecommendations(namespace string) ([]WorkloadRecommendation, error) {
+	pods, err := ma.listPods(namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	refs := make([]PodRef, 0, len(pods))
+	for _, pod := range pods {
+		if ma.skipsOptimization(pod) {
+			continue
+		}
+		refs = append(refs, PodRef{Namespace: pod.Namespace, PodName: pod.Name})
+	}
+
+	var metrics []PodMetrics
+	for _, result := range ma.getMetricsForPods(context.Background(), refs) {
+		if result.Metrics != nil {
+			metrics = append(metrics, *result.Metrics)
+		}
+	}
+	return ma.aggregateWorkloads(metrics), nil
+}
+
+// aggregateWorkloads группирует метрики подов по владеющей рабочей нагрузке. Рекомендация
+// нагрузки, как и рекомендация пода, поднимается до нижних границ из аннотаций и прижимается
+// к границам LimitRange namespace.
+func (ma *MetricsAnalyzer) aggregateWorkloads(metrics []PodMetrics) []WorkloadRecommendation {
+	type group struct {
+		workload WorkloadRecommendation
+		pod      *corev1.Pod // Одна из реплик: аннотации и LimitRange у реплик общие
+		cpu      []float64
+		memory   []float64
+	}
+
+	groups := make(map[string]*group)
+	var order []string
+	for _, podMetrics := range metrics {
+		kind, name := "Pod", podMetrics.PodName
+		pod, err := ma.getPod(podMetrics.Namespace, podMetrics.PodName)
+		if err == nil {
+			kind, name = ma.resolveOwner(pod)
+		}
+
+		key := podMetrics.Namespace + "/" + kind + "/" + name
+		g, ok := groups[key]
+		if !ok {
+			g = &group{workload: WorkloadRecommendation{Kind: kind, Name: name, Namespace: podMetrics.Namespace}, pod: pod}
+			groups[key] = g
+			order = append(order, key)
+		}
+
+		g.workload.ReplicaCount++
+		g.workload.Pods = append(g.workload.Pods, podMetrics.PodName)
+		// Реплики созданы из одного шаблона, максимум защищает от реплик, оставшихся от прошлой ревизии
+		g.workload.CurrentCPU = math.Max(g.workload.CurrentCPU, podMetrics.CurrentCPU)
+		g.workload.CurrentMemory = math.Max(g.workload.CurrentMemory, podMetrics.CurrentMemory)
+		g.cpu = append(g.cpu, podMetrics.MaxCPU)
+		g.memory = append(g.memory, podMetrics.MaxMemory)
+	}
+
+	result := make([]WorkloadRecommendation, 0, len(order))
+	for _, key := range order {
+		g := groups[key]
+		workload := g.workload
+		workload.MaxCPU = percentile(g.cpu, ma.config.ReplicaAggregationPercentile)
+		workload.MaxMemory = percentile(g.memory, ma.config.ReplicaAggregationPercentile)
+
+		rec := ma.recommend(workload.CurrentCPU, workload.CurrentMemory, workload.MaxCPU, workload.MaxMemory, ma.workloadMemoryHeadroom(workload.Kind, ""))
+		if g.pod != nil {
+			rec = ma.clampWorkloadRecommendation(g.pod, rec, workload.CurrentCPU, workload.CurrentMemory)
+		}
+		rec = ma.keepDisabledResources(rec, workload.CurrentCPU, workload.CurrentMemory)
+		workload.RecommendCPU = rec.CPU
+		workload.RecommendMem = rec.Memory
+		workload.OptimizationScore = rec.Score
+		result = append(result, workload)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].OptimizationScore > result[j].OptimizationScore
+	})
+	return result
+}
+
+// clampWorkloadRecommendation применяет к рекомендации нагрузки нижние границы из аннотаций
+// и LimitRange реплики pod. Некорректные аннотации уже отсеяны при анализе подов.
+func (ma *MetricsAnalyzer) clampWorkloadRecommendation(pod *corev1.Pod, rec recommendation, currentCPU, currentMemory float64) recommendation {
+	if floors, err := ma.resourceFloors(pod); err == nil {
+		rec = ma.applyFloors(rec, floors, currentCPU, currentMemory)
+	}
+	if bounds, err := ma.podLimitRangeBounds(pod); err == nil {
+		rec, _ = ma.clampToLimitRange(rec, bounds, currentCPU, currentMemory)
+	}
+	return rec
+}
+
+// collapseReplicas заменяет поды рабочих нагрузок с несколькими репликами одной рекомендацией
+// на нагрузку: применение рекомендаций к каждой реплике по отдельности перезапускало бы
+// одну и ту же нагрузку несколько раз. Порядок оставшихся подов сохраняется.
+func (ma *MetricsAnalyzer) collapseReplicas(metrics []PodMetrics) ([]PodMetrics, []WorkloadRecommendation) {
+	collapsed := make(map[string]bool)
+	var workloads []WorkloadRecommendation
+	for _, workload := range ma.aggregateWorkloads(metrics) {
+		if workload.ReplicaCount < 2 {
+			continue
+		}
+		workloads = append(workloads, workload)
+		for _, podName := range workload.Pods {
+			collapsed[workload.Namespace+"/"+podName] = true
+		}
+	}
+
+	pods := []PodMetrics{}
+	for _, podMetrics := range metrics {
+		if !collapsed[podMetrics.Namespace+"/"+podMetrics.PodName] {
+			pods = append(pods, podMetrics)
+		}
+	}
+	return pods, workloads
+}
+
+// percentile возвращает перцентиль q (0..1) значений методом ближайшего ранга
+func percentile(values []float64, q float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+
+	index := int(math.Ceil(q*float64(len(sorted)))) - 1
+	if index < 0 {
+		index = 0
+	}
+	if index >= len(sorted) {
+		index = len(sorted) - 1
+	}
+	return sorted[index]
+}
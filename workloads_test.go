@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWorkloadRecommendationsHonourAnnotations(t *testing.T) {
+	const mb = 1024 * 1024
+	started := time.Now().Add(-24 * time.Hour)
+	skipped := testPod("default", "failover", started, resources("2", "1Gi"))
+	skipped.Annotations = map[string]string{SkipOptimizationAnnotation: "true"}
+	floored := testPod("default", "web", started, resources("2", "1Gi"))
+	floored.Annotations = map[string]string{MinCPUAnnotation: "500m", MinMemoryAnnotation: "256Mi"}
+	usage := map[string][]containerUsage{
+		"default/failover": {{cpu: 10, memory: 50 * mb}},
+		"default/web":      {{cpu: 10, memory: 50 * mb}},
+	}
+
+	config := testConfig()
+	analyzer := newTestAnalyzer(t, config, &seriesPrometheus{config: config, containers: usage}, skipped, floored)
+
+	workloads, err := analyzer.getWorkloadRecommendations("default")
+	if err != nil {
+		t.Fatalf("getWorkloadRecommendations: %v", err)
+	}
+	if len(workloads) != 1 {
+		t.Fatalf("got %d workloads, want only web: %+v", len(workloads), workloads)
+	}
+	web := workloads[0]
+	if web.Name != "web" {
+		t.Fatalf("workload = %s, want web", web.Name)
+	}
+	if web.RecommendCPU < 0.5 {
+		t.Errorf("RecommendCPU = %g, want at least the 500m floor", web.RecommendCPU)
+	}
+	if web.RecommendMem < 256*mb {
+		t.Errorf("RecommendMem = %g, want at least the 256Mi floor", web.RecommendMem)
+	}
+
+	patch, err := analyzer.podPatch(PodMetrics{Namespace: "default", PodName: "failover"})
+	if err != nil {
+		t.Fatalf("podPatch: %v", err)
+	}
+	if patch.SkipReason == "" {
+		t.Error("podPatch builds a patch for a pod that opts out of optimization")
+	}
+}